@@ -1,44 +1,71 @@
 package main
 
 import (
-	"net/http"
-
 	"github.com/gin-gonic/gin"
 )
 
 type AzMapEntry struct {
-	Subnet string `json:"sub"`
-	AZ     string `json:"az"`
+	Subnet string            `json:"sub"`
+	AZ     string            `json:"az"`
+	Region string            `json:"region,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Weight int               `json:"weight,omitempty"`
 }
 
-// CIDREntry 内网网段配置项
+// CIDREntry 内网网段配置项，View 为客户端所属视图
 type CIDREntry struct {
 	CIDR string `json:"cidr"`
 	Desc string `json:"desc,omitempty"`
+	View string `json:"view,omitempty"`
+}
+
+// ServerEntry 服务器位置配置项，覆盖 GeoIP 数据库中服务器 IP 的位置
+type ServerEntry struct {
+	CIDR       string  `json:"cidr"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Country    string  `json:"country,omitempty"`
+	Region     string  `json:"region,omitempty"`
+	Datacenter string  `json:"datacenter,omitempty"`
+}
+
+// azMapKey 映射条目的主键，AZ 级默认值按 AZ 名称
+func azMapKey(e AzMapEntry) string {
+	if e.Subnet == "" {
+		return "az:" + e.AZ
+	}
+	return e.Subnet
 }
 
 func main() {
 	r := gin.Default()
 
-	// azroute插件API
-	r.GET("/azmap", func(c *gin.Context) {
-		data := []AzMapEntry{
-			{Subnet: "127.0.0.0/24", AZ: "az-01"},
-			{Subnet: "10.90.0.0/24", AZ: "az-02"},
-		}
-		c.JSON(http.StatusOK, data)
+	azmap := newStore(azMapKey, []AzMapEntry{
+		{Subnet: "127.0.0.0/24", AZ: "az-01"},
+		{Subnet: "10.90.0.0/24", AZ: "az-02"},
+		{AZ: "az-01", Region: "region-01", Weight: 2},
+		{AZ: "az-02", Region: "region-01", Weight: 1},
 	})
+	cidrs := newStore(func(e CIDREntry) string { return e.CIDR }, []CIDREntry{
+		{CIDR: "10.0.0.0/8", Desc: "内网A段"},
+		{CIDR: "192.168.0.0/16", Desc: "内网C段"},
+		{CIDR: "172.16.0.0/12", Desc: "内网B段"},
+		{CIDR: "127.0.0.0/8", Desc: "本地回环"},
+		{CIDR: "10.8.0.0/16", Desc: "VPN", View: "vpn"},
+	})
+	servers := newStore(func(e ServerEntry) string { return e.CIDR }, []ServerEntry{
+		{CIDR: "203.0.113.0/24", Latitude: 31.23, Longitude: 121.47, Country: "China", Region: "Shanghai", Datacenter: "sh-01"},
+		{CIDR: "198.51.100.0/24", Latitude: 39.90, Longitude: 116.40, Country: "China", Region: "Beijing", Datacenter: "bj-01"},
+	})
+
+	// azroute插件API
+	register(r, "/azmap", azmap)
 
 	// splitnet插件API
-	r.GET("/internal_cidr", func(c *gin.Context) {
-		cidrList := []CIDREntry{
-			{CIDR: "10.0.0.0/8", Desc: "内网A段"},
-			{CIDR: "192.168.0.0/16", Desc: "内网C段"},
-			{CIDR: "172.16.0.0/12", Desc: "内网B段"},
-			{CIDR: "127.0.0.0/8", Desc: "本地回环"},
-		}
-		c.JSON(http.StatusOK, cidrList)
-	})
+	register(r, "/internal_cidr", cidrs)
+
+	// georoute插件服务器位置API
+	register(r, "/server_location", servers)
 
 	r.Run(":8080")
 }
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// versionHeader 数据版本响应头，与插件 common.VersionHeader 一致
+const versionHeader = "X-Data-Version"
+
+// maxHistory 保留的变更版本数，更早的版本请求增量时返回 410，由插件回退到全量拉取
+const maxHistory = 1000
+
+// maxWait 长轮询最长挂起时间
+const maxWait = 5 * time.Minute
+
+// Delta 增量接口返回的变更
+type Delta[T any] struct {
+	Version string `json:"version"`
+	Added   []T    `json:"added,omitempty"`
+	Removed []T    `json:"removed,omitempty"`
+}
+
+// change 单个版本的变更
+type change[T any] struct {
+	version uint64
+	added   []T
+	removed []T
+}
+
+// store 带版本号的映射数据，记录每个版本的变更以支持增量接口
+type store[T any] struct {
+	mu      sync.RWMutex
+	key     func(T) string
+	items   []T
+	version uint64
+	modTime time.Time
+	body    []byte
+	etag    string
+	history []change[T]
+	changed chan struct{} // 每次变更时关闭并重建，用于唤醒长轮询
+}
+
+func newStore[T any](key func(T) string, items []T) *store[T] {
+	s := &store[T]{key: key, items: items, version: 1, changed: make(chan struct{})}
+	s.rebuild()
+	return s
+}
+
+// rebuild 重新生成全量数据、ETag 与修改时间，调用方需持有写锁
+func (s *store[T]) rebuild() {
+	s.body, _ = json.Marshal(s.items)
+	sum := sha256.Sum256(s.body)
+	s.etag = `"` + hex.EncodeToString(sum[:8]) + `"`
+	s.modTime = time.Now().UTC().Truncate(time.Second)
+}
+
+// apply 应用一次变更并生成新版本：added 中主键已存在的条目视为更新
+func (s *store[T]) apply(added, removed []T) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applyLocked(added, removed)
+}
+
+// applyLocked 同 apply，调用方需持有写锁
+func (s *store[T]) applyLocked(added, removed []T) uint64 {
+	drop := make(map[string]bool, len(removed)+len(added))
+	for _, item := range removed {
+		drop[s.key(item)] = true
+	}
+	for _, item := range added {
+		drop[s.key(item)] = true
+	}
+	items := make([]T, 0, len(s.items)+len(added))
+	for _, item := range s.items {
+		if !drop[s.key(item)] {
+			items = append(items, item)
+		}
+	}
+	s.items = append(items, added...)
+	s.version++
+	s.history = append(s.history, change[T]{version: s.version, added: added, removed: removed})
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	s.rebuild()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return s.version
+}
+
+// replace 用新的全量数据替换，按主键计算新增与删除，生成一个新版本
+func (s *store[T]) replace(items []T) uint64 {
+	keep := make(map[string]bool, len(items))
+	for _, item := range items {
+		keep[s.key(item)] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var removed []T
+	for _, item := range s.items {
+		if !keep[s.key(item)] {
+			removed = append(removed, item)
+		}
+	}
+	return s.applyLocked(items, removed)
+}
+
+// wait 等待版本超过 since，超时或请求取消时返回
+func (s *store[T]) wait(c *gin.Context, since uint64, timeout time.Duration) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.RLock()
+		version, changed := s.version, s.changed
+		s.mu.RUnlock()
+		if version != since {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// delta 计算自 since 版本以来的变更，since 超出保留范围时返回 false
+func (s *store[T]) delta(since uint64) (Delta[T], bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d := Delta[T]{Version: strconv.FormatUint(s.version, 10)}
+	if since > s.version || (since < s.version && (len(s.history) == 0 || s.history[0].version > since+1)) {
+		return d, false
+	}
+	// 按版本顺序折叠变更：同一主键以最后一次变更为准
+	added := make(map[string]T)
+	removed := make(map[string]T)
+	for _, ch := range s.history {
+		if ch.version <= since {
+			continue
+		}
+		for _, item := range ch.removed {
+			k := s.key(item)
+			delete(added, k)
+			removed[k] = item
+		}
+		for _, item := range ch.added {
+			k := s.key(item)
+			delete(removed, k)
+			added[k] = item
+		}
+	}
+	for _, item := range added {
+		d.Added = append(d.Added, item)
+	}
+	for _, item := range removed {
+		d.Removed = append(d.Removed, item)
+	}
+	return d, true
+}
+
+// serveFull 全量接口，支持 If-None-Match / If-Modified-Since 条件请求
+func (s *store[T]) serveFull(c *gin.Context) {
+	s.mu.RLock()
+	body, etag, modTime, version := s.body, s.etag, s.modTime, s.version
+	s.mu.RUnlock()
+	c.Header("ETag", etag)
+	c.Header("Last-Modified", modTime.Format(http.TimeFormat))
+	c.Header(versionHeader, strconv.FormatUint(version, 10))
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		if match == etag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !modTime.After(since) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// serveDelta 增量接口：GET ?since=VERSION[&wait=DURATION]，无变化返回 304，版本过旧或未知返回 410；
+// 携带 wait 时为长轮询，无变化则挂起直到有新版本或超时
+func (s *store[T]) serveDelta(c *gin.Context) {
+	since, err := strconv.ParseUint(c.Query("since"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+		return
+	}
+	if w := c.Query("wait"); w != "" {
+		timeout, err := time.ParseDuration(w)
+		if err != nil || timeout <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid wait"})
+			return
+		}
+		if timeout > maxWait {
+			timeout = maxWait
+		}
+		s.wait(c, since, timeout)
+	}
+	d, ok := s.delta(since)
+	c.Header(versionHeader, d.Version)
+	switch {
+	case !ok:
+		c.JSON(http.StatusGone, gin.H{"error": "version too old, fetch full data"})
+	case d.Version == strconv.FormatUint(since, 10):
+		c.Status(http.StatusNotModified)
+	default:
+		c.JSON(http.StatusOK, d)
+	}
+}
+
+// serveChanges 修改数据（用于测试）：POST {"added": [...], "removed": [...]}，返回新版本
+func (s *store[T]) serveChanges(c *gin.Context) {
+	var d Delta[T]
+	if err := c.ShouldBindJSON(&d); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version := s.apply(d.Added, d.Removed)
+	c.JSON(http.StatusOK, gin.H{"version": strconv.FormatUint(version, 10)})
+}
+
+// serveReplace 替换全量数据（用于测试）：PUT [...]，返回新版本
+func (s *store[T]) serveReplace(c *gin.Context) {
+	var items []T
+	if err := c.ShouldBindJSON(&items); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	version := s.replace(items)
+	c.JSON(http.StatusOK, gin.H{"version": strconv.FormatUint(version, 10)})
+}
+
+// register 注册全量、增量（含长轮询）与修改接口
+func register[T any](r *gin.Engine, path string, s *store[T]) {
+	r.GET(path, s.serveFull)
+	r.PUT(path, s.serveReplace)
+	r.GET(path+"/delta", s.serveDelta)
+	r.POST(path+"/changes", s.serveChanges)
+}
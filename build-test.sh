@@ -43,6 +43,7 @@ echo "复制插件源码..."
 cp -r plugins/azroute/ "$TEMP_DIR/coredns/plugin/"
 cp -r plugins/splitnet/ "$TEMP_DIR/coredns/plugin/"
 cp -r plugins/georoute/ "$TEMP_DIR/coredns/plugin/"
+cp -r plugins/common/ "$TEMP_DIR/coredns/plugin/"
 
 # 修改 plugin.cfg - 避免重复追加
 echo "修改 plugin.cfg..."
@@ -0,0 +1,64 @@
+package azroute
+
+import (
+	"net/netip"
+
+	"coredns-plugins/plugins/common"
+)
+
+// azStatus 管理接口展示的加载状态
+type azStatus struct {
+	TableVersion uint64              `json:"table_version"`
+	CIDRs        int                 `json:"cidrs"`
+	Sources      map[string]int      `json:"sources"`
+	Fetch        *common.FetchStatus `json:"fetch,omitempty"`
+	File         string              `json:"file,omitempty"`
+	AzMap        []AzMapEntry        `json:"azmap"`
+}
+
+// azLookup 管理接口的地址查询结果
+type azLookup struct {
+	Found  bool              `json:"found"`
+	Prefix string            `json:"prefix,omitempty"`
+	AZ     string            `json:"az,omitempty"`
+	Region string            `json:"region,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Weight int               `json:"weight,omitempty"`
+}
+
+// Status 合并后的映射、查找表版本与各来源条目数
+func (a *AzRoute) Status() interface{} {
+	status := azStatus{
+		TableVersion: a.Table.Version(),
+		CIDRs:        a.Table.Len(),
+		Sources:      a.Sources.Counts(),
+		File:         a.FilePath,
+		AzMap:        a.Sources.Merged(),
+	}
+	if a.Fetcher != nil {
+		fetch := a.Fetcher.Status()
+		status.Fetch = &fetch
+	}
+	return status
+}
+
+// Lookup 查询地址所属网段及其 AZ 与拓扑信息（已合并 AZ 默认值）
+func (a *AzRoute) Lookup(ip netip.Addr) interface{} {
+	entry, ok := a.Table.Lookup(ip)
+	if !ok {
+		return azLookup{}
+	}
+	return azLookup{
+		Found:  true,
+		Prefix: entry.Prefix.String(),
+		AZ:     entry.Value.AZ,
+		Region: entry.Value.Region,
+		Labels: entry.Value.Labels,
+		Weight: entry.Value.Weight,
+	}
+}
+
+// CacheStats 网段查找缓存统计
+func (a *AzRoute) CacheStats() interface{} {
+	return a.Table.Stats()
+}
@@ -2,222 +2,372 @@ package azroute
 
 import (
 	context "context"
-	"encoding/json"
-	"io"
-	"log"
-	"net"
-	"net/http"
+	"fmt"
+	"net/netip"
+	"strconv"
 	"strings"
-	"sync"
-	"time"
+
+	"coredns-plugins/plugins/common"
 
 	"github.com/coredns/coredns/plugin"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/coredns/coredns/plugin/metrics"
 	"github.com/miekg/dns"
-	"github.com/yl2chen/cidranger"
 )
 
+// AzMapEntry 网段-AZ映射项。
+// Region、Labels 为可选拓扑信息，Weight 为可选容量权重；
+// 有 sub 时作用于该网段，sub 为空时作为该 AZ 的默认值（网段上的配置优先）
 type AzMapEntry struct {
-	Subnet string `json:"sub"`
-	AZ     string `json:"az"`
+	Subnet string            `json:"sub"`
+	AZ     string            `json:"az"`
+	Region string            `json:"region,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Weight int               `json:"weight,omitempty"`
 }
 
-type AzRoute struct {
-	Next      plugin.Handler
-	AzMap     []AzMapEntry
-	AzMapLock sync.RWMutex
-	ApiUrl    string
-	IpAzMap   map[string]string // IP -> AZ
+// 拓扑层级名称，其余名称按 Labels 中的键取值
+const (
+	LocalityAZ     = "az"
+	LocalityRegion = "region"
+	LocalityGlobal = "global" // 所有层级均无匹配时返回全部记录
+)
 
-	Ranger  cidranger.Ranger // 新增：高效网段查找结构
-	AzCache *lru.Cache       // 新增：LRU缓存
-	LruSize int              // LRU缓存最大容量
+// azInfo 网段查找表中保存的值，构建时已合并所属 AZ 的默认拓扑信息与权重，查询时无需再查 AZ 级配置
+type azInfo struct {
+	AZ     string
+	Region string
+	Labels map[string]string
+	Weight int
 }
 
-type responseCaptureWriter struct {
-	dns.ResponseWriter
-	Msg *dns.Msg
-}
+type AzRoute struct {
+	Next    plugin.Handler
+	Sources common.Sources[AzMapEntry] // API、本地文件与内联映射，同一网段（或同一 AZ 的默认值）以 Corefile 内联 > 本地文件 > API 为准
+	ApiUrl  string
 
-func (r *responseCaptureWriter) WriteMsg(res *dns.Msg) error {
-	r.Msg = res
-	return nil // 不直接写出，由 azroute 处理
+	Table    *common.CIDRTable[azInfo] // 网段->AZ 查找表（Trie + LRU缓存），热加载时原子替换
+	LruSize  int                       // LRU缓存最大容量
+	Fetcher  *common.Fetcher           // API 定时拉取
+	Fetch    common.FetchConfig        // API 刷新间隔、超时与失败退避
+	Snapshot string                    // 快照文件路径，为空时不持久化
+	FilePath string                    // 本地映射文件路径（JSON/YAML/CSV）
+	File     *common.FileWatcher       // 本地映射文件监听
+	Inline   []AzMapEntry              // Corefile 内联映射
+	Locality []string                  // 就近回退层级，如 az region，依次尝试
+
+	Answers common.AnswerConfig // 按容量权重排列/选择返回记录及数量上限
+
+	TTL        common.TTLPolicy     // 按命中层级限制返回记录的 TTL
+	DNSSEC     common.DNSSEC        // 下游返回已签名记录时的处理方式
+	EcsTrusted common.ECSTrusted    // 允许携带 ECS 的递归解析器网段
+	Health     common.HealthChecker // 后端健康检查，未配置规则时不过滤
+	Log        *common.Logger       // 插件日志
+	AdminAddr  string               // 管理接口监听地址，为空时不启用
 }
 
 func (a *AzRoute) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	// 捕获下游（如 hosts）插件的响应
-	rw := &responseCaptureWriter{ResponseWriter: w}
+	// 捕获下游（如 hosts、forward、file）插件的响应，在该响应上原地筛选
+	rw := common.NewResponseCaptureWriter(w)
 	code, err := plugin.NextOrFailure(a.Name(), a.Next, ctx, rw, r)
-	if err != nil || rw.Msg == nil || len(rw.Msg.Answer) == 0 {
+	if err != nil || rw.Msg == nil {
 		return code, err
 	}
-	// 仅有一个地址时没有必要判断可用区逻辑直接返回
-	if len(rw.Msg.Answer) == 1 {
+	// 只筛选 CNAME 链末端的 A/AAAA 记录；仅有一个地址或签名记录不能过滤时没有必要判断可用区逻辑直接返回
+	addrs, others := common.AddrRRs(rw.Msg)
+	if len(addrs) <= 1 || a.DNSSEC.Skip(r, addrs, others) {
+		// 原样返回的记录不区分可用区，按 global 层级限制 TTL；只调低 TTL，原签名仍然有效
+		common.CapTTL(rw.Msg, addrs, a.TTL.Cap(LocalityGlobal, a.Fetcher.Degraded()))
 		w.WriteMsg(rw.Msg)
-		return dns.RcodeSuccess, nil
+		return code, err
 	}
 
-	clientIP := getClientIP(w.RemoteAddr().String())
-	az := a.findAZ(clientIP)
-	log.Printf("[azroute] clientIP=%s, matched AZ=%s", clientIP, az)
+	clientIP, ecs := common.ClientSubnet(w, r, a.EcsTrusted)
+	client, clientFound := a.Table.Lookup(clientIP)
+	verbose := a.Log.Sampled()
 
-	var answers []dns.RR
 	var allAnswers []dns.RR
-	var allIPs []string
-	for _, rr := range rw.Msg.Answer {
-		switch v := rr.(type) {
-		case *dns.A:
-			allAnswers = append(allAnswers, rr)
-			allIPs = append(allIPs, v.A.String())
-			if az != "" && a.findAZ(v.A.String()) == az {
-				answers = append(answers, rr)
-			}
-		case *dns.AAAA:
-			allAnswers = append(allAnswers, rr)
-			allIPs = append(allIPs, v.AAAA.String())
-			if az != "" && a.findAZ(v.AAAA.String()) == az {
-				answers = append(answers, rr)
-			}
-		default:
-			// 其他类型直接透传
-		}
+	var infos []azInfo
+	// 先剔除不健康的后端，再按拓扑层级就近选择
+	for _, rr := range a.Health.Filter(addrs) {
+		ip, _ := common.RRAddr(rr)
+		entry, _ := a.Table.Lookup(ip)
+		allAnswers = append(allAnswers, rr)
+		infos = append(infos, entry.Value)
 	}
-	log.Printf("[azroute] hosts returned IPs: %v", allIPs)
-	// 如果没有同 AZ 的，返回全部 A/AAAA
-	if len(answers) == 0 || len(allIPs) == 1 {
-		answers = allAnswers
+	// 按拓扑层级就近选择，所有层级均无匹配时返回全部 A/AAAA
+	tier, answers, answerInfos := LocalityGlobal, allAnswers, infos
+	if len(allAnswers) > 1 {
+		tier, answers, answerInfos = a.selectTier(client.Value, allAnswers, infos)
 	}
-	var retIPs []string
-	for _, rr := range answers {
-		switch v := rr.(type) {
-		case *dns.A:
-			retIPs = append(retIPs, v.A.String())
-		case *dns.AAAA:
-			retIPs = append(retIPs, v.AAAA.String())
-		}
+	server := metrics.WithServer(ctx)
+	clientAZ := client.Value.AZ
+	if clientAZ == "" {
+		clientAZ = "unknown"
 	}
-	log.Printf("[azroute] final returned IPs: %v", retIPs)
-	if len(answers) == 0 {
-		return code, err
+	requestCount.WithLabelValues(server, clientAZ, tier).Inc()
+	if tier == LocalityGlobal {
+		fallbackCount.WithLabelValues(server).Inc()
+	}
+	clientKey := a.Answers.ClientKey(clientIP, ecs)
+	answers = a.selectAnswers(r, clientKey, answers, answerInfos)
+	// API 拉取失败时映射可能已过时，按降级上限缩短 TTL 以便尽快重新解析
+	degraded := a.Fetcher.Degraded()
+	ttl := a.TTL.Cap(tier, degraded)
+	// 模拟解析（管理接口 dryrun）时无论是否采样都记录决策
+	if trace := common.TraceFrom(ctx); verbose || trace != nil {
+		d := common.NewDecision(clientIP, ecs != nil, r, tier, answers)
+		d.Attrs = map[string]string{"az": client.Value.AZ}
+		if a.Answers.Rendezvous() {
+			d.Attrs["sticky"] = clientKey
+		}
+		d.SetTTL(ttl, degraded)
+		if verbose {
+			a.Log.Debugf("clientIP=%s, matched AZ=%s, hosts returned IPs: %v, final returned IPs: %v, tier=%s",
+				clientIP, client.Value.AZ, common.Addrs(allAnswers), common.Addrs(answers), tier)
+			a.Log.Decision(d)
+		}
+		trace.Add(a.Name(), d)
 	}
 
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Answer = answers
+	m := rw.Msg
+	if ecs != nil {
+		common.SetECSScope(m, r, m, ecs, a.ecsScope(clientIP, ecs, client, clientFound))
+	}
+	fitted, signErr := common.SetFiltered(w, r, m, &a.DNSSEC, others, addrs, answers)
+	if signErr != nil {
+		a.Log.Warningf("%v", signErr)
+	}
+	common.CapTTL(m, fitted, ttl)
+	if verbose && len(fitted) < len(answers) {
+		a.Log.Debugf("answers trimmed from %d to %d to fit UDP size", len(answers), len(fitted))
+	}
 	w.WriteMsg(m)
-	return dns.RcodeSuccess, nil
+	return code, err
 }
 
-func (a *AzRoute) findAZ(ip string) string {
-	if a.AzCache != nil {
-		if v, ok := a.AzCache.Get(ip); ok {
-			return v.(string)
-		}
-	}
-	a.AzMapLock.RLock()
-	defer a.AzMapLock.RUnlock()
-	if a.Ranger == nil {
-		return ""
+// ecsScope 计算 ECS 响应的 SCOPE PREFIX-LENGTH：命中网段（client 为客户端地址的查找结果）时取该网段掩码长度，
+// 否则沿用 SOURCE 长度；rendezvous 模式下应答还取决于客户端标识，作用范围不小于其前缀长度
+func (a *AzRoute) ecsScope(ip netip.Addr, ecs *dns.EDNS0_SUBNET, client common.Entry[azInfo], found bool) uint8 {
+	scope := ecs.SourceNetmask
+	if found {
+		scope = uint8(client.Prefix.Bits())
 	}
-	ipAddr := net.ParseIP(ip)
-	entries, err := a.Ranger.ContainingNetworks(ipAddr)
-	if err != nil || len(entries) == 0 {
-		if a.AzCache != nil {
-			a.AzCache.Add(ip, "")
+	return a.Answers.Scope(ip, ecs, scope)
+}
+
+// selectTier 依次尝试各拓扑层级，返回第一个与客户端同层级取值且非空的记录集合及其网段信息
+func (a *AzRoute) selectTier(client azInfo, answers []dns.RR, infos []azInfo) (string, []dns.RR, []azInfo) {
+	for _, key := range a.Locality {
+		want := a.localityValue(client, key)
+		if want == "" {
+			continue
+		}
+		var matched []dns.RR
+		var matchedInfos []azInfo
+		for i, rr := range answers {
+			if a.localityValue(infos[i], key) == want {
+				matched = append(matched, rr)
+				matchedInfos = append(matchedInfos, infos[i])
+			}
 		}
-		return ""
+		if len(matched) > 0 {
+			return key, matched, matchedInfos
+		}
+	}
+	return LocalityGlobal, answers, infos
+}
+
+// localityValue 取网段在某一拓扑层级上的值
+func (a *AzRoute) localityValue(info azInfo, key string) string {
+	switch key {
+	case LocalityAZ:
+		return info.AZ
+	case LocalityRegion:
+		return info.Region
 	}
-	if azEntry, ok := entries[0].(*azRangerEntry); ok {
-		if a.AzCache != nil {
-			a.AzCache.Add(ip, azEntry.AZ())
+	return info.Labels[key]
+}
+
+// selectAnswers 按后端所在网段/AZ 的容量权重排列并选出返回的记录，infos 为各记录的网段信息；
+// rendezvous 模式按客户端标识选择，其他模式按查询名称与类型
+func (a *AzRoute) selectAnswers(r *dns.Msg, clientKey string, answers []dns.RR, infos []azInfo) []dns.RR {
+	var weights []int
+	if a.Answers.Selector != nil {
+		weights = make([]int, len(answers))
+		for i := range answers {
+			weights[i] = weightOf(infos[i])
 		}
-		return azEntry.AZ()
 	}
-	if a.AzCache != nil {
-		a.AzCache.Add(ip, "")
+	return a.Answers.Select(a.Answers.Key(r, clientKey), answers, weights)
+}
+
+// weightOf 后端的权重：网段权重优先，其次为 AZ 默认权重（构建查找表时已合并），均未配置或未命中网段时为 1
+func weightOf(info azInfo) int {
+	if info.Weight <= 0 {
+		return 1
 	}
-	return ""
+	return info.Weight
 }
 
 func (a *AzRoute) Name() string { return "azroute" }
 
+// InitAndUpdateAzMap 加载内联映射，并启动本地文件监听与 API 定时拉取
 func (a *AzRoute) InitAndUpdateAzMap() {
-	a.fetchAzMap()
-	size := a.LruSize
-	if size <= 0 {
-		size = 1024 // 默认值
-	}
-	cache, err := lru.New(size)
-	if err != nil {
-		log.Printf("[azroute] LRU缓存初始化失败: %v", err)
-	} else {
-		a.AzCache = cache
-	}
-	go func() {
-		for {
-			time.Sleep(60 * time.Second)
-			a.fetchAzMap()
-		}
-	}()
-}
-
-func (a *AzRoute) fetchAzMap() {
-	resp, err := http.Get(a.ApiUrl)
-	if err != nil {
-		log.Printf("[azroute] fetch API error: %v", err)
-		return
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[azroute] read API body error: %v", err)
-		return
-	}
-	var azmap []AzMapEntry
-	if err := json.Unmarshal(body, &azmap); err != nil {
-		log.Printf("[azroute] unmarshal API json error: %v", err)
-		return
-	}
-	a.AzMapLock.Lock()
-	a.AzMap = azmap
-	// 构建Ranger
-	ranger := cidranger.NewPCTrieRanger()
-	for _, entry := range azmap {
-		_, network, err := net.ParseCIDR(entry.Subnet)
-		if err == nil {
-			ranger.Insert(&azRangerEntry{network: *network, az: entry.AZ})
+	a.Table = common.NewCIDRTable[azInfo](a.LruSize)
+	a.Table.OnLookup = observeLookup
+	a.Sources.Name = "映射"
+	a.Sources.Log = a.Log
+	a.Sources.Key = azMapKey
+	a.Sources.Format = common.FormatOf(a.FilePath)
+	a.Sources.FromRecord = azMapRecord
+	a.Sources.Build = a.rebuild
+	if len(a.Inline) > 0 {
+		a.Sources.Set(common.SourceInline, a.Inline)
+	}
+	if a.FilePath != "" {
+		a.File = &common.FileWatcher{
+			Log:    a.Log,
+			Path:   a.FilePath,
+			OnData: a.Sources.LoadFile,
 		}
+		a.File.Start()
 	}
-	a.Ranger = ranger
-	if a.AzCache != nil {
-		a.AzCache.Purge() // 热加载时清空缓存
+	if a.ApiUrl != "" {
+		a.Fetcher = &common.Fetcher{
+			FetchConfig: a.Fetch,
+			Log:         a.Log,
+			URL:         a.ApiUrl,
+			OnData:      a.Sources.LoadAPI,
+			OnDelta:     a.Sources.LoadDelta,
+			Observe:     observeFetch,
+			Snapshot:    a.Snapshot,
+			OnSnapshot:  observeSnapshot,
+		}
+		a.Fetcher.Start()
 	}
-	a.AzMapLock.Unlock()
-	log.Printf("[azroute] API数据已热加载")
 }
 
-// azRangerEntry实现cidranger.RangerEntry接口
+// Stop 停止本地文件监听与 API 拉取
+func (a *AzRoute) Stop() error {
+	if a.File != nil {
+		a.File.Stop()
+	}
+	if a.Fetcher != nil {
+		a.Fetcher.Stop()
+	}
+	return nil
+}
 
-type azRangerEntry struct {
-	network net.IPNet
-	az      string
+// azMapRecord 解析 CSV 映射文件的一行，sub、az、region、weight 为内置列，其余列作为拓扑标签
+func azMapRecord(record map[string]string) (AzMapEntry, error) {
+	entry := AzMapEntry{Subnet: record["sub"], AZ: record["az"], Region: record["region"]}
+	if w, ok := record["weight"]; ok {
+		var err error
+		if entry.Weight, err = strconv.Atoi(w); err != nil {
+			return entry, fmt.Errorf("invalid weight: %s", w)
+		}
+	}
+	for k, v := range record {
+		switch k {
+		case "sub", "az", "region", "weight":
+			continue
+		}
+		if entry.Labels == nil {
+			entry.Labels = make(map[string]string)
+		}
+		entry.Labels[k] = v
+	}
+	return entry, nil
 }
 
-func (e *azRangerEntry) Network() net.IPNet {
-	return e.network
+// parseInlineEntry 解析 Corefile 内联映射：SUBNET AZ [region=REGION] [weight=N] [LABEL=VALUE...]
+func parseInlineEntry(subnet string, args []string) (AzMapEntry, error) {
+	if _, err := netip.ParsePrefix(subnet); err != nil {
+		return AzMapEntry{}, err
+	}
+	if len(args) == 0 {
+		return AzMapEntry{}, fmt.Errorf("missing az for %s", subnet)
+	}
+	entry := AzMapEntry{Subnet: subnet, AZ: args[0]}
+	for _, arg := range args[1:] {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || k == "" || v == "" {
+			return AzMapEntry{}, fmt.Errorf("invalid attribute: %s", arg)
+		}
+		switch k {
+		case "region":
+			entry.Region = v
+		case "weight":
+			w, err := strconv.Atoi(v)
+			if err != nil || w <= 0 {
+				return AzMapEntry{}, fmt.Errorf("invalid weight: %s", v)
+			}
+			entry.Weight = w
+		default:
+			if entry.Labels == nil {
+				entry.Labels = make(map[string]string)
+			}
+			entry.Labels[k] = v
+		}
+	}
+	return entry, nil
 }
 
-func (e *azRangerEntry) AZ() string {
-	return e.az
+// azMapKey 映射条目的主键：网段（按掩码归一化），AZ 级默认值按 AZ 名称
+func azMapKey(e AzMapEntry) string {
+	if e.Subnet == "" {
+		return "az:" + e.AZ
+	}
+	if prefix, err := netip.ParsePrefix(e.Subnet); err == nil {
+		return prefix.Masked().String()
+	}
+	return e.Subnet
 }
 
-// getClientIP 提取客户端IP
-func getClientIP(addr string) string {
-	if strings.Contains(addr, "[") { // IPv6
-		addr = strings.Split(addr, "]:")[0]
-		addr = strings.TrimPrefix(addr, "[")
-	} else {
-		addr = strings.Split(addr, ":")[0]
+// buildAzEntries 生成查找表条目：网段未配置的 region、labels、weight 取所属 AZ 的默认值（sub 为空的条目）
+func buildAzEntries(azmap []AzMapEntry) []common.Entry[azInfo] {
+	azMeta := make(map[string]AzMapEntry)
+	for _, entry := range azmap {
+		if entry.Subnet == "" && entry.AZ != "" {
+			azMeta[entry.AZ] = entry
+		}
 	}
-	return addr
+	entries := make([]common.Entry[azInfo], 0, len(azmap))
+	for _, entry := range azmap {
+		if entry.Subnet == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry.Subnet)
+		if err != nil {
+			continue
+		}
+		meta := azMeta[entry.AZ]
+		info := azInfo{AZ: entry.AZ, Region: entry.Region, Weight: entry.Weight}
+		if info.Region == "" {
+			info.Region = meta.Region
+		}
+		if info.Weight <= 0 {
+			info.Weight = meta.Weight
+		}
+		info.Labels = meta.Labels
+		if len(entry.Labels) > 0 {
+			info.Labels = make(map[string]string, len(meta.Labels)+len(entry.Labels))
+			for k, v := range meta.Labels {
+				info.Labels[k] = v
+			}
+			for k, v := range entry.Labels {
+				info.Labels[k] = v
+			}
+		}
+		entries = append(entries, common.Entry[azInfo]{Prefix: prefix.Masked(), Value: info})
+	}
+	return entries
+}
+
+// rebuild 用合并后的映射在旁路构建新的查找表并原子替换
+func (a *AzRoute) rebuild(azmap []AzMapEntry) int {
+	count := a.Table.Replace(buildAzEntries(azmap))
+	cidrEntries.Set(float64(count))
+	reloadTime.SetToCurrentTime()
+	return count
 }
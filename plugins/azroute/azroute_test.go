@@ -0,0 +1,134 @@
+package azroute
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+func TestBuildAzEntriesWeight(t *testing.T) {
+	azmap := []AzMapEntry{
+		{AZ: "az1", Weight: 4},
+		{Subnet: "10.1.0.0/16", AZ: "az1"},
+		{Subnet: "10.1.1.0/24", AZ: "az1", Weight: 2},
+		{Subnet: "10.2.0.0/16", AZ: "az2"},
+	}
+	table := common.NewCIDRTable[azInfo](0)
+	table.Replace(buildAzEntries(azmap))
+	tests := []struct {
+		ip   string
+		want int
+	}{
+		{ip: "10.1.0.1", want: 4},     // 网段未配置权重，取 AZ 默认权重
+		{ip: "10.1.1.1", want: 2},     // 网段权重优先
+		{ip: "10.2.0.1", want: 1},     // 均未配置
+		{ip: "198.51.100.1", want: 1}, // 未命中网段
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			entry, _ := table.Lookup(netip.MustParseAddr(tt.ip))
+			if got := weightOf(entry.Value); got != tt.want {
+				t.Errorf("weightOf(%s) = %d, want %d", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func() (AzMapEntry, error)
+		want    int
+		wantErr bool
+	}{
+		{name: "inline", parse: func() (AzMapEntry, error) { return parseInlineEntry("10.1.0.0/16", []string{"az1", "weight=3"}) }, want: 3},
+		{name: "inline zero", parse: func() (AzMapEntry, error) { return parseInlineEntry("10.1.0.0/16", []string{"az1", "weight=0"}) }, wantErr: true},
+		{name: "inline invalid", parse: func() (AzMapEntry, error) { return parseInlineEntry("10.1.0.0/16", []string{"az1", "weight=x"}) }, wantErr: true},
+		{name: "csv", parse: func() (AzMapEntry, error) {
+			return azMapRecord(map[string]string{"sub": "10.1.0.0/16", "az": "az1", "weight": "5"})
+		}, want: 5},
+		{name: "csv invalid", parse: func() (AzMapEntry, error) {
+			return azMapRecord(map[string]string{"sub": "10.1.0.0/16", "az": "az1", "weight": "x"})
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := tt.parse()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && entry.Weight != tt.want {
+				t.Errorf("Weight = %d, want %d", entry.Weight, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeDNSWeighted(t *testing.T) {
+	azmap := []AzMapEntry{
+		{Subnet: "10.1.0.0/16", AZ: "az1", Weight: 3},
+		{Subnet: "10.2.0.0/16", AZ: "az2", Weight: 1},
+	}
+	tests := []struct {
+		mode string
+		want map[string]int // 40 次查询中各地址排在首位的次数
+	}{
+		{mode: common.SelectWeightedRoundRobin, want: map[string]int{"10.1.0.1": 30, "10.2.0.1": 10}},
+		{mode: common.SelectRoundRobin, want: map[string]int{"10.1.0.1": 20, "10.2.0.1": 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			a := &AzRoute{Log: common.NewLogger("azroute"), Table: common.NewCIDRTable[azInfo](0)}
+			a.Answers.Selector = common.NewSelector(tt.mode)
+			a.Answers.MaxAnswers = 1
+			a.Table.Replace(buildAzEntries(azmap))
+			a.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+				m := new(dns.Msg)
+				m.SetReply(r)
+				for _, ip := range []string{"10.1.0.1", "10.2.0.1"} {
+					rr, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A %s", r.Question[0].Name, ip))
+					m.Answer = append(m.Answer, rr)
+				}
+				w.WriteMsg(m)
+				return dns.RcodeSuccess, nil
+			})
+			counts := map[string]int{}
+			for i := 0; i < 40; i++ {
+				rec := &recorder{ResponseWriter: &test.ResponseWriter{RemoteIP: "198.51.100.1"}}
+				r := new(dns.Msg)
+				r.SetQuestion("www.example.org.", dns.TypeA)
+				if _, err := a.ServeDNS(context.Background(), rec, r); err != nil {
+					t.Fatal(err)
+				}
+				if len(rec.msg.Answer) != 1 {
+					t.Fatalf("answer = %v, want 1 record", rec.msg.Answer)
+				}
+				counts[rec.msg.Answer[0].(*dns.A).A.String()]++
+			}
+			for ip, n := range tt.want {
+				if counts[ip] != n {
+					t.Errorf("counts = %v, want %v", counts, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+// recorder 记录写出的响应
+type recorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (r *recorder) WriteMsg(m *dns.Msg) error {
+	r.msg = m
+	return nil
+}
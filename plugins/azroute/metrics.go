@@ -0,0 +1,107 @@
+package azroute
+
+import (
+	"errors"
+	"time"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// requestCount 按客户端所属 AZ 与最终命中的拓扑层级统计的请求数
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "requests_total",
+		Help:      "Counter of requests filtered by azroute, by client AZ and matched locality tier.",
+	}, []string{"server", "az", "tier"})
+	// fallbackCount 没有就近记录、回退返回全部记录的次数
+	fallbackCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "fallback_total",
+		Help:      "Counter of requests that fell back to returning all records.",
+	}, []string{"server"})
+	// cacheHits AzCache 命中次数
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "cache_hits_total",
+		Help:      "Counter of IP to AZ lookups served from the LRU cache.",
+	})
+	// cacheMisses AzCache 未命中次数
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "cache_misses_total",
+		Help:      "Counter of IP to AZ lookups that missed the LRU cache.",
+	})
+	// fetchCount azmap API 拉取次数，按结果区分
+	fetchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "fetch_total",
+		Help:      "Counter of azmap API fetches, by result.",
+	}, []string{"result"})
+	// fetchDuration azmap API 拉取耗时
+	fetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "fetch_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time taken to fetch and load the azmap API.",
+	})
+	// cidrEntries 当前加载的网段数
+	cidrEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "cidrs",
+		Help:      "The number of subnets currently loaded in the azmap.",
+	})
+	// reloadTime 最近一次成功热加载的时间戳
+	reloadTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "reload_timestamp_seconds",
+		Help:      "The timestamp of the last successful azmap reload.",
+	})
+	// snapshotTime 快照文件对应的拉取时间
+	snapshotTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "azroute",
+		Name:      "snapshot_timestamp_seconds",
+		Help:      "The modification time of the azmap snapshot file last loaded or written.",
+	})
+)
+
+// observeLookup 统计 LRU 缓存命中情况
+func observeLookup(hit bool) {
+	if hit {
+		cacheHits.Inc()
+	} else {
+		cacheMisses.Inc()
+	}
+}
+
+// observeFetch 统计 API 拉取结果与耗时
+func observeFetch(err error, duration time.Duration) {
+	fetchDuration.Observe(duration.Seconds())
+	switch {
+	case err == nil:
+		fetchCount.WithLabelValues("success").Inc()
+	case errors.Is(err, common.ErrNotModified):
+		fetchCount.WithLabelValues("unchanged").Inc()
+	default:
+		fetchCount.WithLabelValues("failure").Inc()
+	}
+}
+
+// observeSnapshot 记录快照时间，快照年龄为当前时间与该值之差
+func observeSnapshot(modTime time.Time) {
+	snapshotTime.Set(float64(modTime.Unix()))
+}
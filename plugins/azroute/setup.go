@@ -2,6 +2,9 @@ package azroute
 
 import (
 	"fmt"
+	"net/netip"
+
+	"coredns-plugins/plugins/common"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -13,31 +16,98 @@ func init() { plugin.Register("azroute", setup) }
 
 func setup(c *caddy.Controller) error {
 	clog.Info("[azroute] setup called")
-	azroute := &AzRoute{}
+	azroute := &AzRoute{Log: common.NewLogger("azroute")}
 
+	// 三个插件共用的指令
+	shared := []common.DirectiveParser{azroute.Log, &azroute.DNSSEC, &azroute.Answers, &azroute.TTL, &azroute.Fetch, &azroute.EcsTrusted, &azroute.Health}
 	for c.Next() {
 		for c.NextBlock() {
+			if ok, err := common.ParseDirectives(c, shared...); ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			switch c.Val() {
-			case "azmap_api":
+			case "azmap_api", "api_url":
 				if !c.NextArg() {
 					return c.ArgErr()
 				}
 				azroute.ApiUrl = c.Val()
-			case "lru_size":
+			case "lru_size", "cache_size":
+				name := c.Val()
 				if !c.NextArg() {
 					return c.ArgErr()
 				}
 				var size int
 				_, err := fmt.Sscanf(c.Val(), "%d", &size)
 				if err != nil || size <= 0 {
-					return c.Errf("invalid lru_size value: %s", c.Val())
+					return c.Errf("invalid %s value: %s", name, c.Val())
 				}
 				azroute.LruSize = size
+			case "locality":
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return c.ArgErr()
+				}
+				azroute.Locality = args
+			case "snapshot_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				azroute.Snapshot = c.Val()
+			case "azmap_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				azroute.FilePath = c.Val()
+			case "admin":
+				addr, err := common.AdminDirective(c)
+				if err != nil {
+					return err
+				}
+				azroute.AdminAddr = addr
+			default:
+				// 以网段开头的行为内联映射，其他未知指令报错
+				if _, err := netip.ParsePrefix(c.Val()); err != nil {
+					return c.Errf("unknown property '%s'", c.Val())
+				}
+				entry, err := parseInlineEntry(c.Val(), c.RemainingArgs())
+				if err != nil {
+					return c.Errf("invalid inline azmap entry: %v", err)
+				}
+				azroute.Inline = append(azroute.Inline, entry)
 			}
 		}
 	}
 
+	if azroute.ApiUrl == "" && azroute.FilePath == "" && len(azroute.Inline) == 0 {
+		return c.Err("azmap_api, azmap_file or inline entries required")
+	}
+	if err := azroute.Fetch.Validate(); err != nil {
+		return c.Err(err.Error())
+	}
+	if len(azroute.Locality) == 0 {
+		azroute.Locality = []string{LocalityAZ}
+	}
+	if err := azroute.TTL.Validate(append([]string{LocalityGlobal}, azroute.Locality...)...); err != nil {
+		return c.Err(err.Error())
+	}
+	// 仅配置 answer_count/max_answers 时默认按权重随机选择
+	if azroute.Answers.MaxAnswers > 0 && azroute.Answers.Selector == nil {
+		azroute.Answers.Selector = common.NewSelector(common.SelectWeightedRandom)
+	}
+
+	if azroute.Health.Enabled() {
+		azroute.Health.Log = azroute.Log
+		azroute.Health.Start()
+		c.OnShutdown(azroute.Health.Stop)
+	}
 	azroute.InitAndUpdateAzMap()
+	c.OnShutdown(azroute.Stop)
+	if azroute.AdminAddr != "" {
+		common.RegisterAdmin(c, azroute.AdminAddr, azroute)
+	}
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		azroute.Next = next
 		return azroute
@@ -0,0 +1,332 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
+)
+
+// routePlugins 参与路由过滤的插件，模拟解析时从其中在插件链上最靠前的一个开始执行
+var routePlugins = map[string]bool{"georoute": true, "splitnet": true, "azroute": true}
+
+// dryRunTimeout 单次模拟解析的超时时间，包含下游插件（如 forward）的耗时
+const dryRunTimeout = 5 * time.Second
+
+// Inspector 可通过管理接口查看状态的插件
+type Inspector interface {
+	Name() string
+	// Status 当前加载的数据、版本与拉取状态
+	Status() interface{}
+	// Lookup 查询单个地址在本插件中的匹配结果
+	Lookup(ip netip.Addr) interface{}
+	// CacheStats 查找缓存统计
+	CacheStats() interface{}
+}
+
+// CacheStats 查找缓存统计
+type CacheStats struct {
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// AdminDirective 解析 admin 指令：admin ADDR
+func AdminDirective(c *caddy.Controller) (string, error) {
+	if !c.NextArg() {
+		return "", c.ArgErr()
+	}
+	addr := c.Val()
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", c.Errf("invalid admin address: %s", addr)
+	}
+	return addr, nil
+}
+
+// RegisterAdmin 在 setup 中调用：服务启动后将插件注册到 addr 上的管理接口，关闭时注销。
+// 同一地址上的所有插件实例共用一个监听，首个注册时启动，最后一个注销时关闭
+func RegisterAdmin(c *caddy.Controller, addr string, in Inspector) {
+	cfg := dnsserver.GetConfig(c)
+	var unregister func()
+	c.OnStartup(func() error {
+		var err error
+		unregister, err = registerAdmin(addr, cfg, in)
+		return err
+	})
+	c.OnShutdown(func() error {
+		if unregister != nil {
+			unregister()
+		}
+		return nil
+	})
+}
+
+// adminEntry 注册到管理接口的插件实例
+type adminEntry struct {
+	cfg *dnsserver.Config
+	in  Inspector
+}
+
+// adminServer 同一监听地址上的管理接口
+type adminServer struct {
+	addr    string
+	srv     *http.Server
+	mu      sync.RWMutex
+	entries []*adminEntry
+}
+
+var (
+	adminLock    sync.Mutex
+	adminServers = make(map[string]*adminServer)
+)
+
+func registerAdmin(addr string, cfg *dnsserver.Config, in Inspector) (func(), error) {
+	adminLock.Lock()
+	defer adminLock.Unlock()
+	s := adminServers[addr]
+	if s == nil {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		s = &adminServer{addr: addr}
+		s.srv = &http.Server{Handler: s.handler(), ReadHeaderTimeout: 5 * time.Second}
+		go s.srv.Serve(ln)
+		adminServers[addr] = s
+		clog.Infof("admin endpoint listening on %s", addr)
+	}
+	entry := &adminEntry{cfg: cfg, in: in}
+	s.mu.Lock()
+	s.entries = append(s.entries, entry)
+	s.mu.Unlock()
+	return func() { s.remove(entry) }, nil
+}
+
+// remove 注销插件实例，没有剩余实例时关闭监听
+func (s *adminServer) remove(entry *adminEntry) {
+	adminLock.Lock()
+	defer adminLock.Unlock()
+	s.mu.Lock()
+	for i, e := range s.entries {
+		if e == entry {
+			s.entries = append(s.entries[:i], s.entries[i+1:]...)
+			break
+		}
+	}
+	empty := len(s.entries) == 0
+	s.mu.Unlock()
+	if empty {
+		s.srv.Close()
+		delete(adminServers, s.addr)
+	}
+}
+
+func (s *adminServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.serveStatus)
+	mux.HandleFunc("/lookup", s.serveLookup)
+	mux.HandleFunc("/cache", s.serveCache)
+	mux.HandleFunc("/dryrun", s.serveDryRun)
+	return mux
+}
+
+// pluginResult 单个插件实例的返回结果
+type pluginResult struct {
+	Server string      `json:"server"`
+	Plugin string      `json:"plugin"`
+	Result interface{} `json:"result"`
+}
+
+// each 对所有插件实例执行 fn，按注册顺序返回结果
+func (s *adminServer) each(fn func(Inspector) interface{}) []pluginResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	results := make([]pluginResult, 0, len(s.entries))
+	for _, e := range s.entries {
+		results = append(results, pluginResult{Server: serverName(e.cfg), Plugin: e.in.Name(), Result: fn(e.in)})
+	}
+	return results
+}
+
+// serveStatus GET /status 各插件当前加载的数据、版本与拉取时间
+func (s *adminServer) serveStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.each(Inspector.Status))
+}
+
+// serveCache GET /cache 各插件的缓存统计
+func (s *adminServer) serveCache(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.each(Inspector.CacheStats))
+}
+
+// serveLookup GET /lookup?ip=IP 查询地址在各插件中的匹配结果
+func (s *adminServer) serveLookup(w http.ResponseWriter, r *http.Request) {
+	ip, err := netip.ParseAddr(r.URL.Query().Get("ip"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ip"})
+		return
+	}
+	ip = ip.Unmap()
+	writeJSON(w, http.StatusOK, s.each(func(in Inspector) interface{} { return in.Lookup(ip) }))
+}
+
+// dryRunResult 单个 server block 的模拟解析结果
+type dryRunResult struct {
+	Server  string     `json:"server"`
+	Entry   string     `json:"entry"`
+	Rcode   string     `json:"rcode,omitempty"`
+	Answers []string   `json:"answers,omitempty"`
+	Trace   []TraceHop `json:"trace,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// serveDryRun GET /dryrun?client=IP&qname=NAME[&qtype=A] 以 client 作为来源地址模拟一次查询，
+// 从插件链上最靠前的路由插件（georoute/splitnet/azroute）开始执行到下游，返回最终应答与各路由插件的决策
+func (s *adminServer) serveDryRun(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	client, err := netip.ParseAddr(query.Get("client"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid client"})
+		return
+	}
+	qname := query.Get("qname")
+	if _, ok := dns.IsDomainName(qname); qname == "" || !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid qname"})
+		return
+	}
+	qtype := dns.TypeA
+	if t := query.Get("qtype"); t != "" {
+		var ok bool
+		if qtype, ok = dns.StringToType[strings.ToUpper(t)]; !ok {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid qtype"})
+			return
+		}
+	}
+
+	// 同一 server block 的多个插件共用一条插件链，只模拟一次
+	s.mu.RLock()
+	var configs []*dnsserver.Config
+	seen := make(map[*dnsserver.Config]bool)
+	for _, e := range s.entries {
+		if !seen[e.cfg] {
+			seen[e.cfg] = true
+			configs = append(configs, e.cfg)
+		}
+	}
+	s.mu.RUnlock()
+
+	results := make([]dryRunResult, 0, len(configs))
+	for _, cfg := range configs {
+		results = append(results, dryRun(r.Context(), cfg, client.Unmap(), dns.Fqdn(qname), qtype))
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// dryRun 在 server block 的插件链上模拟一次查询
+func dryRun(ctx context.Context, cfg *dnsserver.Config, client netip.Addr, qname string, qtype uint16) dryRunResult {
+	res := dryRunResult{Server: serverName(cfg)}
+	entry := entryHandler(cfg)
+	if entry == nil {
+		res.Error = "no route plugin in server block"
+		return res
+	}
+	res.Entry = entry.Name()
+
+	req := new(dns.Msg)
+	req.SetQuestion(qname, qtype)
+	ctx, cancel := context.WithTimeout(ctx, dryRunTimeout)
+	defer cancel()
+	ctx, trace := WithTrace(ctx)
+	w := &dryRunWriter{client: client}
+	rcode, err := entry.ServeDNS(ctx, w, req)
+	res.Trace = trace.Hops()
+	if err != nil {
+		res.Error = err.Error()
+	}
+	msg := w.msg
+	if msg == nil {
+		// 插件未写出响应时按返回码生成应答，与 dnsserver 的处理一致
+		if !plugin.ClientWrite(rcode) {
+			res.Rcode = dns.RcodeToString[rcode]
+		}
+		return res
+	}
+	res.Rcode = dns.RcodeToString[msg.Rcode]
+	for _, rr := range msg.Answer {
+		res.Answers = append(res.Answers, rr.String())
+	}
+	return res
+}
+
+// entryHandler 按插件执行顺序找到 server block 中最靠前的路由插件
+func entryHandler(cfg *dnsserver.Config) plugin.Handler {
+	for _, name := range dnsserver.Directives {
+		if !routePlugins[name] {
+			continue
+		}
+		if h := cfg.Handler(name); h != nil {
+			return h
+		}
+	}
+	return nil
+}
+
+// serverName server block 标识，如 dns://example.org.:53
+func serverName(cfg *dnsserver.Config) string {
+	transport := cfg.Transport
+	if transport == "" {
+		transport = "dns"
+	}
+	return transport + "://" + cfg.Zone + ":" + cfg.Port
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// dryRunWriter 模拟解析使用的 ResponseWriter：来源地址为指定客户端，只记录响应不发送
+type dryRunWriter struct {
+	client netip.Addr
+	msg    *dns.Msg
+}
+
+func (w *dryRunWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 53}
+}
+
+func (w *dryRunWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: w.client.AsSlice(), Port: 53}
+}
+
+func (w *dryRunWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *dryRunWriter) Write(b []byte) (int, error) {
+	m := new(dns.Msg)
+	if err := m.Unpack(b); err != nil {
+		return 0, err
+	}
+	w.msg = m
+	return len(b), nil
+}
+
+func (w *dryRunWriter) Close() error        { return nil }
+func (w *dryRunWriter) TsigStatus() error   { return nil }
+func (w *dryRunWriter) TsigTimersOnly(bool) {}
+func (w *dryRunWriter) Hijack()             {}
@@ -0,0 +1,160 @@
+package common
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// AnswerConfig 返回记录的排列方式与数量上限
+type AnswerConfig struct {
+	Selector   *Selector // 排列/选择方式，nil 表示保持过滤后的顺序
+	MaxAnswers int       // 每次最多返回的地址记录数，0 表示不限制
+	StickyBits [2]int    // rendezvous 模式下客户端标识的 IPv4/IPv6 前缀长度，同一前缀内的客户端选中相同记录
+}
+
+// ParseDirective 解析返回记录相关指令，返回 false 表示不是该类指令：
+//
+//	selection random|round_robin|weighted_random|weighted_round_robin|rendezvous
+//	max_answers|answer_count N
+//	sticky_prefix V4_BITS [V6_BITS]
+func (a *AnswerConfig) ParseDirective(c *caddy.Controller) (bool, error) {
+	name := c.Val()
+	switch name {
+	case "sticky_prefix":
+		args := c.RemainingArgs()
+		if len(args) == 0 || len(args) > 2 {
+			return true, c.ArgErr()
+		}
+		for i, arg := range args {
+			var bits int
+			_, err := fmt.Sscanf(arg, "%d", &bits)
+			if err != nil || bits <= 0 || bits > [2]int{32, 128}[i] {
+				return true, c.Errf("invalid sticky_prefix value: %s", arg)
+			}
+			a.StickyBits[i] = bits
+		}
+		return true, nil
+	case "selection":
+		if !c.NextArg() {
+			return true, c.ArgErr()
+		}
+		mode, err := ParseSelectMode(c.Val())
+		if err != nil {
+			return true, c.Errf("invalid selection value: %s", c.Val())
+		}
+		a.Selector = NewSelector(mode)
+	case "max_answers", "answer_count":
+		if !c.NextArg() {
+			return true, c.ArgErr()
+		}
+		var n int
+		_, err := fmt.Sscanf(c.Val(), "%d", &n)
+		if err != nil || n <= 0 {
+			return true, c.Errf("invalid %s value: %s", name, c.Val())
+		}
+		a.MaxAnswers = n
+	default:
+		return false, nil
+	}
+	if c.NextArg() {
+		return true, c.ArgErr()
+	}
+	return true, nil
+}
+
+// Validate 校验选择方式：后端没有权重的插件（weighted 为 false）不接受 weighted_* 模式
+func (a *AnswerConfig) Validate(weighted bool) error {
+	if a.Selector != nil && !weighted && strings.HasPrefix(a.Selector.Mode, "weighted_") {
+		return fmt.Errorf("selection %s requires backend weights, use random, round_robin or rendezvous", a.Selector.Mode)
+	}
+	return nil
+}
+
+// Rendezvous 是否按客户端标识做 rendezvous 哈希选择
+func (a *AnswerConfig) Rendezvous() bool {
+	return a.Selector != nil && a.Selector.Mode == SelectRendezvous
+}
+
+// stickyBits 客户端标识的前缀长度，不超过 ECS 的 SOURCE 长度
+func (a *AnswerConfig) stickyBits(ip netip.Addr, ecs *dns.EDNS0_SUBNET) int {
+	bits := ip.BitLen()
+	if ip.Is4() && a.StickyBits[0] > 0 {
+		bits = a.StickyBits[0]
+	} else if ip.Is6() && a.StickyBits[1] > 0 {
+		bits = a.StickyBits[1]
+	}
+	if ecs != nil && int(ecs.SourceNetmask) < bits {
+		bits = int(ecs.SourceNetmask)
+	}
+	return bits
+}
+
+// ClientKey rendezvous 模式下的客户端标识：客户端地址（或 ECS 地址）按前缀长度截断后的网段
+func (a *AnswerConfig) ClientKey(ip netip.Addr, ecs *dns.EDNS0_SUBNET) string {
+	if !ip.IsValid() {
+		return ""
+	}
+	prefix, _ := ip.Prefix(a.stickyBits(ip, ecs))
+	return prefix.String()
+}
+
+// Scope rendezvous 模式下应答还取决于客户端标识，ECS 的 SCOPE 不小于其前缀长度
+func (a *AnswerConfig) Scope(ip netip.Addr, ecs *dns.EDNS0_SUBNET, scope uint8) uint8 {
+	if a.Rendezvous() {
+		if bits := uint8(a.stickyBits(ip, ecs)); bits > scope {
+			return bits
+		}
+	}
+	return scope
+}
+
+// Key Selector 的状态键：rendezvous 模式为客户端标识（见 ClientKey），其他模式为查询名称与类型
+func (a *AnswerConfig) Key(r *dns.Msg, client string) string {
+	if a.Rendezvous() {
+		return client
+	}
+	return r.Question[0].Name + "/" + dns.TypeToString[r.Question[0].Qtype]
+}
+
+// Select 按配置排列记录并截取最多 MaxAnswers 条；weights 为 nil 时权重均为 1
+func (a *AnswerConfig) Select(key string, rrs []dns.RR, weights []int) []dns.RR {
+	if a.Selector != nil {
+		return a.Selector.Select(key, rrs, weights, a.MaxAnswers)
+	}
+	if a.MaxAnswers > 0 && len(rrs) > a.MaxAnswers {
+		return rrs[:a.MaxAnswers]
+	}
+	return rrs
+}
+
+// SetFiltered 将过滤结果写入捕获的响应：替换最终 A/AAAA 记录集并按 DNSSEC 配置处理签名。
+// UDP 查询时响应超过客户端缓冲区大小则从末尾逐条减少地址记录（至少保留 1 条），
+// 避免服务器截断响应并设置 TC 位、使客户端改用 TCP 重试；返回实际写入的地址记录
+func SetFiltered(w dns.ResponseWriter, r, m *dns.Msg, sec *DNSSEC, others, addrs, filtered []dns.RR) ([]dns.RR, error) {
+	state := request.Request{W: w, Req: r}
+	size := state.Size()
+	for {
+		SetAddrRRs(m, others, filtered)
+		if err := sec.Sign(r, m, addrs, filtered); err != nil {
+			return filtered, err
+		}
+		if state.Proto() != "udp" || len(filtered) <= 1 || compressedLen(m) <= size {
+			return filtered, nil
+		}
+		filtered = filtered[:len(filtered)-1]
+	}
+}
+
+// compressedLen 压缩后的响应长度，与 CoreDNS 写出响应前判断是否截断的方式一致
+func compressedLen(m *dns.Msg) int {
+	compress := m.Compress
+	m.Compress = true
+	n := m.Len()
+	m.Compress = compress
+	return n
+}
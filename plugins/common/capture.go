@@ -0,0 +1,59 @@
+package common
+
+import "github.com/miekg/dns"
+
+// ResponseCaptureWriter 捕获下游插件响应，不直接写出，由上层插件在该响应上原地过滤后再写回客户端
+type ResponseCaptureWriter struct {
+	dns.ResponseWriter
+	Msg *dns.Msg
+}
+
+// NewResponseCaptureWriter 包装原始 ResponseWriter
+func NewResponseCaptureWriter(w dns.ResponseWriter) *ResponseCaptureWriter {
+	return &ResponseCaptureWriter{ResponseWriter: w}
+}
+
+// WriteMsg 仅记录响应
+func (r *ResponseCaptureWriter) WriteMsg(res *dns.Msg) error {
+	r.Msg = res
+	return nil
+}
+
+// AddrRRs 拆分应答段：addrs 为问题名称沿 CNAME 链到达的最终名称下的 A/AAAA 记录，参与筛选；
+// others 为其余记录（CNAME 链、签名及其他类型），原样保留
+func AddrRRs(m *dns.Msg) (addrs, others []dns.RR) {
+	if len(m.Question) == 0 {
+		return nil, m.Answer
+	}
+	// 沿 CNAME 链找到最终名称，链长度不超过应答记录数，避免循环
+	name := m.Question[0].Name
+	for i := 0; i < len(m.Answer); i++ {
+		next := ""
+		for _, rr := range m.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && dns.CanonicalName(cname.Hdr.Name) == dns.CanonicalName(name) {
+				next = cname.Target
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	for _, rr := range m.Answer {
+		if _, ok := RRAddr(rr); ok && dns.CanonicalName(rr.Header().Name) == dns.CanonicalName(name) {
+			addrs = append(addrs, rr)
+			continue
+		}
+		others = append(others, rr)
+	}
+	return addrs, others
+}
+
+// SetAddrRRs 在捕获的响应上原地替换最终 A/AAAA 记录集，CNAME 链等其余记录排在前面；
+// 权威段、附加段（含 OPT/DO）、AA 标志与 rcode 保持下游返回的值
+func SetAddrRRs(m *dns.Msg, others, addrs []dns.RR) {
+	answer := make([]dns.RR, 0, len(others)+len(addrs))
+	answer = append(answer, others...)
+	m.Answer = append(answer, addrs...)
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// answerMsg 构造问题为 www.example.org. A、应答段为 records 的响应
+func answerMsg(t *testing.T, records ...string) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Answer = append(m.Answer, rr)
+	}
+	return m
+}
+
+// rrStrings 记录的文本形式
+func rrStrings(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		out = append(out, rr.String())
+	}
+	return out
+}
+
+func TestAddrRRs(t *testing.T) {
+	const (
+		cname1 = "www.example.org. 60 IN CNAME edge.example.net."
+		cname2 = "Edge.Example.net. 60 IN CNAME pop1.cdn.example."
+		loopA  = "www.example.org. 60 IN CNAME loop.example.org."
+		loopB  = "loop.example.org. 60 IN CNAME www.example.org."
+		direct = "www.example.org. 60 IN A 192.0.2.1"
+		edge   = "edge.example.net. 60 IN A 192.0.2.2"
+		pop1   = "pop1.cdn.example. 60 IN A 192.0.2.3"
+		pop1v6 = "pop1.cdn.example. 60 IN AAAA 2001:db8::3"
+		txt    = "pop1.cdn.example. 60 IN TXT \"x\""
+	)
+	tests := []struct {
+		name       string
+		answer     []string
+		noQuestion bool
+		wantAddrs  []string
+		wantOthers []string
+	}{
+		{name: "direct", answer: []string{direct}, wantAddrs: []string{direct}},
+		{name: "one hop", answer: []string{cname1, edge}, wantAddrs: []string{edge}, wantOthers: []string{cname1}},
+		{
+			name: "multi hop case insensitive", answer: []string{pop1, cname2, edge, cname1, pop1v6, txt},
+			wantAddrs: []string{pop1, pop1v6}, wantOthers: []string{cname2, edge, cname1, txt},
+		},
+		{name: "cname loop", answer: []string{loopA, loopB, direct}, wantOthers: []string{loopA, loopB, direct}},
+		{name: "chain without addresses", answer: []string{cname1, direct}, wantOthers: []string{cname1, direct}},
+		{name: "no question", answer: []string{direct}, noQuestion: true, wantOthers: []string{direct}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := answerMsg(t, tt.answer...)
+			if tt.noQuestion {
+				m.Question = nil
+			}
+			addrs, others := AddrRRs(m)
+			if got, want := rrStrings(addrs), rrStrings(answerMsg(t, tt.wantAddrs...).Answer); !reflect.DeepEqual(got, want) {
+				t.Errorf("addrs = %v, want %v", got, want)
+			}
+			if got, want := rrStrings(others), rrStrings(answerMsg(t, tt.wantOthers...).Answer); !reflect.DeepEqual(got, want) {
+				t.Errorf("others = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestSetAddrRRs(t *testing.T) {
+	m := answerMsg(t,
+		"edge.example.net. 60 IN A 192.0.2.1",
+		"www.example.org. 60 IN CNAME edge.example.net.",
+		"edge.example.net. 60 IN A 192.0.2.2",
+	)
+	m.Authoritative, m.Rcode = true, dns.RcodeSuccess
+	m.SetEdns0(4096, true)
+	addrs, others := AddrRRs(m)
+	SetAddrRRs(m, others, addrs[1:])
+	want := []string{
+		"www.example.org.\t60\tIN\tCNAME\tedge.example.net.",
+		"edge.example.net.\t60\tIN\tA\t192.0.2.2",
+	}
+	if got := rrStrings(m.Answer); !reflect.DeepEqual(got, want) {
+		t.Errorf("answer = %v, want %v", got, want)
+	}
+	if !m.Authoritative || m.IsEdns0() == nil || !m.IsEdns0().Do() {
+		t.Errorf("header or OPT record changed: %v", m)
+	}
+}
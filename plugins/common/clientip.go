@@ -0,0 +1,158 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// ClientAddr 提取客户端地址
+func ClientAddr(w dns.ResponseWriter) netip.Addr {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		return AddrFromIP(addr.IP)
+	case *net.TCPAddr:
+		return AddrFromIP(addr.IP)
+	}
+	ap, err := netip.ParseAddrPort(w.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// ECSTrusted 受信任的递归解析器网段，仅采信来自这些地址的 ECS
+type ECSTrusted []netip.Prefix
+
+// ParseDirective 解析 ECS 信任指令，返回 false 表示不是该指令：
+//
+//	ecs_trusted CIDR|IP...
+func (t *ECSTrusted) ParseDirective(c *caddy.Controller) (bool, error) {
+	if c.Val() != "ecs_trusted" {
+		return false, nil
+	}
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return true, c.ArgErr()
+	}
+	for _, arg := range args {
+		prefix, err := ParsePrefix(arg)
+		if err != nil {
+			return true, c.Errf("invalid ecs_trusted value: %s", arg)
+		}
+		*t = append(*t, prefix)
+	}
+	return true, nil
+}
+
+// ClientSubnet 获取用于路由的客户端地址。
+// 若请求来自受信任的递归解析器且携带 EDNS0 Client Subnet（SOURCE 长度非 0），则使用 ECS 中的网段地址，并返回该 ECS 选项
+func ClientSubnet(w dns.ResponseWriter, r *dns.Msg, trusted []netip.Prefix) (netip.Addr, *dns.EDNS0_SUBNET) {
+	client := ClientAddr(w)
+	ecs := GetECS(r)
+	if ecs == nil || ecs.SourceNetmask == 0 || !PrefixesContain(trusted, client) {
+		return client, nil
+	}
+	addr := AddrFromIP(ecs.Address)
+	if !addr.IsValid() {
+		return client, nil
+	}
+	return addr, ecs
+}
+
+// GetECS 提取消息中的 EDNS0 Client Subnet 选项
+func GetECS(m *dns.Msg) *dns.EDNS0_SUBNET {
+	if m == nil {
+		return nil
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// SetECSScope 在响应中回写 ECS 选项（SOURCE 与请求一致），SCOPE 取本插件与下游插件中更精细的值
+func SetECSScope(m, req, upstream *dns.Msg, ecs *dns.EDNS0_SUBNET, scope uint8) {
+	if prev := GetECS(upstream); prev != nil && prev.SourceScope > scope {
+		scope = prev.SourceScope
+	}
+	opt := m.IsEdns0()
+	if opt == nil {
+		reqOpt := req.IsEdns0()
+		m.SetEdns0(reqOpt.UDPSize(), reqOpt.Do())
+		opt = m.IsEdns0()
+	}
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_SUBNET); !ok {
+			options = append(options, o)
+		}
+	}
+	opt.Option = append(options, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        ecs.Family,
+		SourceNetmask: ecs.SourceNetmask,
+		SourceScope:   scope,
+		Address:       ecs.Address,
+	})
+}
+
+// AddrFromIP 将 net.IP 转换为 netip.Addr（IPv4-mapped 地址还原为 IPv4）
+func AddrFromIP(ip net.IP) netip.Addr {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// RRAddr 返回 A/AAAA 记录中的地址，其他类型返回 false
+func RRAddr(rr dns.RR) (netip.Addr, bool) {
+	switch v := rr.(type) {
+	case *dns.A:
+		return AddrFromIP(v.A), true
+	case *dns.AAAA:
+		return AddrFromIP(v.AAAA), true
+	}
+	return netip.Addr{}, false
+}
+
+// ParsePrefix 解析网段或单个IP（单个IP视为 /32 或 /128）
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address: %s", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// PrefixesContain 判断地址是否落在任一网段内
+func PrefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package common
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// ecsRequest 构造携带 ECS 的 A 查询，source 为 0 时同样携带（表示客户端不希望按网段应答）
+func ecsRequest(addr string, source uint8) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	r.SetEdns0(1232, true)
+	if addr == "" {
+		return r
+	}
+	ip := net.ParseIP(addr)
+	family := uint16(1)
+	if ip.To4() == nil {
+		family = 2
+	}
+	opt := r.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        family,
+		SourceNetmask: source,
+		Address:       ip,
+	})
+	return r
+}
+
+func TestClientSubnet(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.240.0.0/16")}
+	tests := []struct {
+		name    string
+		remote  string
+		ecs     string
+		source  uint8
+		want    string
+		wantECS bool
+	}{
+		{name: "no ecs", remote: "10.240.0.1", want: "10.240.0.1"},
+		{name: "trusted resolver", remote: "10.240.0.1", ecs: "198.51.100.0", source: 24, want: "198.51.100.0", wantECS: true},
+		{name: "trusted resolver ipv6", remote: "10.240.0.1", ecs: "2001:db8::", source: 56, want: "2001:db8::", wantECS: true},
+		{name: "untrusted resolver", remote: "203.0.113.1", ecs: "198.51.100.0", source: 24, want: "203.0.113.1"},
+		{name: "source 0", remote: "10.240.0.1", ecs: "0.0.0.0", source: 0, want: "10.240.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &test.ResponseWriter{RemoteIP: tt.remote}
+			addr, ecs := ClientSubnet(w, ecsRequest(tt.ecs, tt.source), trusted)
+			if addr != netip.MustParseAddr(tt.want) {
+				t.Errorf("addr = %s, want %s", addr, tt.want)
+			}
+			if (ecs != nil) != tt.wantECS {
+				t.Errorf("ecs = %v, want present %v", ecs, tt.wantECS)
+			}
+		})
+	}
+}
+
+func TestSetECSScope(t *testing.T) {
+	tests := []struct {
+		name          string
+		responseOpt   bool  // 响应已有 OPT 且带下游的 ECS
+		upstreamScope uint8 // 下游响应中的 SCOPE
+		scope         uint8
+		want          uint8
+	}{
+		{name: "response without opt", scope: 16, want: 16},
+		{name: "plugin scope finer", responseOpt: true, upstreamScope: 16, scope: 24, want: 24},
+		{name: "upstream scope finer", responseOpt: true, upstreamScope: 24, scope: 16, want: 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := ecsRequest("198.51.100.0", 24)
+			ecs := GetECS(r)
+			m := new(dns.Msg)
+			m.SetReply(r)
+			if tt.responseOpt {
+				m.SetEdns0(1232, true)
+				opt := m.IsEdns0()
+				opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+					Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, SourceScope: tt.upstreamScope, Address: ecs.Address,
+				})
+			}
+			SetECSScope(m, r, m, ecs, tt.scope)
+			opt := m.IsEdns0()
+			if opt == nil {
+				t.Fatal("response has no OPT")
+			}
+			if opt.UDPSize() != 1232 || !opt.Do() {
+				t.Errorf("OPT = size %d do %v, want request values", opt.UDPSize(), opt.Do())
+			}
+			var subnets []*dns.EDNS0_SUBNET
+			for _, o := range opt.Option {
+				if e, ok := o.(*dns.EDNS0_SUBNET); ok {
+					subnets = append(subnets, e)
+				}
+			}
+			if len(subnets) != 1 {
+				t.Fatalf("response has %d ECS options, want 1", len(subnets))
+			}
+			if got := subnets[0]; got.SourceNetmask != 24 || got.SourceScope != tt.want || !got.Address.Equal(ecs.Address) {
+				t.Errorf("ECS = %v, want source 24 scope %d", got, tt.want)
+			}
+		})
+	}
+}
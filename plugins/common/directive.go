@@ -0,0 +1,18 @@
+package common
+
+import "github.com/coredns/caddy"
+
+// DirectiveParser 三个插件共用的一类指令，返回 false 表示不是该类指令
+type DirectiveParser interface {
+	ParseDirective(c *caddy.Controller) (bool, error)
+}
+
+// ParseDirectives 依次交给各共用指令解析，返回 false 表示都不是，由插件自行处理
+func ParseDirectives(c *caddy.Controller, parsers ...DirectiveParser) (bool, error) {
+	for _, p := range parsers {
+		if ok, err := p.ParseDirective(c); ok {
+			return true, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,190 @@
+package common
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// DNSSEC 处理方式
+const (
+	// DNSSECSkip 请求带 DO 且最终 A/AAAA 记录集已签名时不过滤，原样返回下游响应（默认）
+	DNSSECSkip = "skip"
+	// DNSSECSign 过滤后用配置的区域密钥在线重新签名，没有签名者对应的密钥时按 skip 处理
+	DNSSECSign = "sign"
+)
+
+// 在线签名的有效期，与 CoreDNS dnssec 插件一致：生效时间提前 3 小时，有效期 8 天
+const (
+	signInception  = 3 * time.Hour
+	signExpiration = 8 * 24 * time.Hour
+)
+
+// SigningKey 区域签名密钥（dnssec-keygen 生成的 .key/.private 文件）
+type SigningKey struct {
+	Key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+// DNSSEC 下游返回已签名记录时的处理方式：过滤 A/AAAA 记录会使原 RRSIG 失效
+type DNSSEC struct {
+	Mode string        // skip 或 sign，为空时为 skip
+	Keys []*SigningKey // sign 模式的区域密钥
+}
+
+// ParseDirective 解析 DNSSEC 指令，返回 false 表示不是 DNSSEC 指令：
+//
+//	dnssec skip
+//	dnssec sign KEYFILE...
+func (d *DNSSEC) ParseDirective(c *caddy.Controller) (bool, error) {
+	if c.Val() != "dnssec" {
+		return false, nil
+	}
+	args := c.RemainingArgs()
+	if len(args) == 0 {
+		return true, c.ArgErr()
+	}
+	switch args[0] {
+	case DNSSECSkip:
+		if len(args) != 1 {
+			return true, c.ArgErr()
+		}
+	case DNSSECSign:
+		if len(args) == 1 {
+			return true, c.Errf("dnssec sign requires at least one key file")
+		}
+		for _, base := range args[1:] {
+			key, err := ReadSigningKey(base)
+			if err != nil {
+				return true, c.Errf("invalid dnssec key %s: %v", base, err)
+			}
+			d.Keys = append(d.Keys, key)
+		}
+	default:
+		return true, c.Errf("invalid dnssec mode: %s", args[0])
+	}
+	d.Mode = args[0]
+	return true, nil
+}
+
+// ReadSigningKey 读取密钥文件，base 为不带扩展名的文件名（如 Kexample.com.+013+12345），
+// 也可以是 .key 或 .private 文件路径
+func ReadSigningKey(base string) (*SigningKey, error) {
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".key"), ".private")
+	pub, err := os.Open(base + ".key")
+	if err != nil {
+		return nil, err
+	}
+	defer pub.Close()
+	rr, err := dns.ReadRR(pub, base+".key")
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, errors.New("no public key found")
+	}
+	priv, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer priv.Close()
+	p, err := key.ReadPrivateKey(priv, base+".private")
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := p.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key")
+	}
+	return &SigningKey{Key: key, signer: signer}, nil
+}
+
+// addrSigs 返回覆盖最终 A/AAAA 记录集的签名
+func addrSigs(addrs, others []dns.RR) []*dns.RRSIG {
+	if len(addrs) == 0 {
+		return nil
+	}
+	name, qtype := addrs[0].Header().Name, addrs[0].Header().Rrtype
+	var sigs []*dns.RRSIG
+	for _, rr := range others {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.TypeCovered == qtype && strings.EqualFold(sig.Hdr.Name, name) {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// keysFor 签名者对应的区域密钥
+func (d *DNSSEC) keysFor(signer string) []*SigningKey {
+	var keys []*SigningKey
+	for _, k := range d.Keys {
+		if strings.EqualFold(k.Key.Hdr.Name, signer) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Skip 请求带 DO 且最终 A/AAAA 记录集已签名、又不能重新签名时返回 true，插件应原样返回下游响应
+func (d *DNSSEC) Skip(r *dns.Msg, addrs, others []dns.RR) bool {
+	if opt := r.IsEdns0(); opt == nil || !opt.Do() {
+		return false
+	}
+	sigs := addrSigs(addrs, others)
+	if len(sigs) == 0 {
+		return false
+	}
+	return d.Mode != DNSSECSign || len(d.keysFor(sigs[0].SignerName)) == 0
+}
+
+// Sign 过滤改变了最终 A/AAAA 记录集时删除失效的签名；sign 模式下请求带 DO 时用区域密钥重新签名。
+// addrs 为过滤前的记录集，m 为已替换为过滤结果的响应
+func (d *DNSSEC) Sign(r *dns.Msg, m *dns.Msg, addrs, filtered []dns.RR) error {
+	if len(filtered) == len(addrs) {
+		return nil
+	}
+	sigs := addrSigs(addrs, m.Answer)
+	if len(sigs) == 0 {
+		return nil
+	}
+	stale := make(map[*dns.RRSIG]bool, len(sigs))
+	for _, sig := range sigs {
+		stale[sig] = true
+	}
+	answer := m.Answer[:0]
+	for _, rr := range m.Answer {
+		if sig, ok := rr.(*dns.RRSIG); !ok || !stale[sig] {
+			answer = append(answer, rr)
+		}
+	}
+	m.Answer = answer
+	opt := r.IsEdns0()
+	if d.Mode != DNSSECSign || opt == nil || !opt.Do() || len(filtered) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	for _, k := range d.keysFor(sigs[0].SignerName) {
+		sig := &dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: filtered[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: filtered[0].Header().Ttl},
+			TypeCovered: filtered[0].Header().Rrtype,
+			Algorithm:   k.Key.Algorithm,
+			OrigTtl:     sigs[0].OrigTtl,
+			Inception:   uint32(now.Add(-signInception).Unix()),
+			Expiration:  uint32(now.Add(signExpiration).Unix()),
+			KeyTag:      k.Key.KeyTag(),
+			SignerName:  k.Key.Hdr.Name,
+		}
+		if err := sig.Sign(k.signer, filtered); err != nil {
+			return fmt.Errorf("sign %s/%s: %w", sig.Hdr.Name, dns.TypeToString[sig.TypeCovered], err)
+		}
+		m.Answer = append(m.Answer, sig)
+	}
+	return nil
+}
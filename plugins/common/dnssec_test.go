@@ -0,0 +1,151 @@
+package common
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// newTestKey 生成 example.org. 的 ECDSA P-256 区域密钥
+func newTestKey(t *testing.T) *SigningKey {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.org.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &SigningKey{Key: key, signer: priv.(crypto.Signer)}
+}
+
+// signedResponse 构造带签名的 www.example.org. A 记录应答
+func signedResponse(t *testing.T, k *SigningKey, ips ...string) (*dns.Msg, []dns.RR) {
+	t.Helper()
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	m := new(dns.Msg)
+	m.SetReply(r)
+	rrs := aRecords(t, "www.example.org.", 300, ips...)
+	now := time.Now().UTC()
+	sig := &dns.RRSIG{
+		Algorithm:  k.Key.Algorithm,
+		OrigTtl:    300,
+		Inception:  uint32(now.Add(-time.Hour).Unix()),
+		Expiration: uint32(now.Add(time.Hour).Unix()),
+		KeyTag:     k.Key.KeyTag(),
+		SignerName: k.Key.Hdr.Name,
+	}
+	if err := sig.Sign(k.signer, rrs); err != nil {
+		t.Fatal(err)
+	}
+	m.Answer = append(append(m.Answer, rrs...), sig)
+	return m, rrs
+}
+
+func TestDNSSECSkip(t *testing.T) {
+	k := newTestKey(t)
+	signed, _ := signedResponse(t, k, "192.0.2.1", "192.0.2.2")
+	unsigned := signed.Copy()
+	unsigned.Answer = unsigned.Answer[:2]
+
+	tests := []struct {
+		name string
+		sec  DNSSEC
+		do   bool
+		msg  *dns.Msg
+		want bool
+	}{
+		{"do signed", DNSSEC{}, true, signed, true},
+		{"do unsigned", DNSSEC{}, true, unsigned, false},
+		{"no do signed", DNSSEC{}, false, signed, false},
+		{"no do unsigned", DNSSEC{}, false, unsigned, false},
+		{"sign with key", DNSSEC{Mode: DNSSECSign, Keys: []*SigningKey{k}}, true, signed, false},
+		{"sign without key", DNSSEC{Mode: DNSSECSign}, true, signed, true},
+	}
+	for _, tc := range tests {
+		r := new(dns.Msg)
+		r.SetQuestion("www.example.org.", dns.TypeA)
+		r.SetEdns0(4096, tc.do)
+		addrs, others := AddrRRs(tc.msg)
+		if got := tc.sec.Skip(r, addrs, others); got != tc.want {
+			t.Errorf("%s: Skip() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDNSSECSign(t *testing.T) {
+	k := newTestKey(t)
+	sec := DNSSEC{Mode: DNSSECSign, Keys: []*SigningKey{k}}
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	r.SetEdns0(4096, true)
+
+	m, rrs := signedResponse(t, k, "192.0.2.1", "192.0.2.2", "192.0.2.3")
+	addrs, others := AddrRRs(m)
+	filtered := rrs[:2]
+	SetAddrRRs(m, others, filtered)
+	if err := sec.Sign(r, m, addrs, filtered); err != nil {
+		t.Fatal(err)
+	}
+
+	var sigs []*dns.RRSIG
+	for _, rr := range m.Answer {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+		}
+	}
+	if len(sigs) != 1 {
+		t.Fatalf("expected 1 RRSIG, got %d", len(sigs))
+	}
+	if err := sigs[0].Verify(k.Key, filtered); err != nil {
+		t.Errorf("re-signed RRSIG does not verify: %v", err)
+	}
+	if !sigs[0].ValidityPeriod(time.Now()) {
+		t.Errorf("re-signed RRSIG is not valid now")
+	}
+}
+
+func TestDNSSECStrip(t *testing.T) {
+	k := newTestKey(t)
+	tests := []struct {
+		name string
+		mode string
+		do   bool
+	}{
+		{"skip mode", DNSSECSkip, true},
+		{"sign mode without do", DNSSECSign, false},
+	}
+	for _, tc := range tests {
+		sec := DNSSEC{Mode: tc.mode, Keys: []*SigningKey{k}}
+		r := new(dns.Msg)
+		r.SetQuestion("www.example.org.", dns.TypeA)
+		r.SetEdns0(4096, tc.do)
+
+		m, rrs := signedResponse(t, k, "192.0.2.1", "192.0.2.2")
+		addrs, others := AddrRRs(m)
+		SetAddrRRs(m, others, rrs[:1])
+		if err := sec.Sign(r, m, addrs, rrs[:1]); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Answer) != 1 {
+			t.Errorf("%s: expected stale RRSIG removed, got %v", tc.name, m.Answer)
+		}
+	}
+
+	// 记录集未被过滤时保留原签名
+	m, rrs := signedResponse(t, k, "192.0.2.1", "192.0.2.2")
+	addrs, others := AddrRRs(m)
+	SetAddrRRs(m, others, rrs)
+	if err := (&DNSSEC{}).Sign(new(dns.Msg), m, addrs, rrs); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answer) != 3 {
+		t.Errorf("unfiltered set: expected original RRSIG kept, got %v", m.Answer)
+	}
+}
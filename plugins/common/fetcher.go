@@ -0,0 +1,472 @@
+package common
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/caddy"
+)
+
+// 拉取默认参数
+const (
+	DefaultInterval   = 60 * time.Second // 默认刷新间隔
+	DefaultTimeout    = 10 * time.Second // 默认 HTTP 请求超时
+	DefaultBackoffMin = time.Second      // 拉取失败后首次重试的默认间隔
+	DefaultLongPoll   = 30 * time.Second // 长轮询默认挂起时间
+)
+
+// VersionHeader 接口返回数据版本的响应头，增量接口以该版本作为游标
+const VersionHeader = "X-Data-Version"
+
+// ErrNotModified 接口数据未变化，无需重建
+var ErrNotModified = errors.New("not modified")
+
+// FetchConfig API 拉取参数，零值表示使用默认值
+type FetchConfig struct {
+	DeltaURL   string        // 增量接口地址，为空时每次全量拉取
+	LongPoll   time.Duration // 增量接口长轮询挂起时间，0 表示不启用
+	Interval   time.Duration // 刷新间隔
+	Timeout    time.Duration // HTTP 请求超时
+	BackoffMin time.Duration // 失败后首次重试间隔，之后指数增长
+	BackoffMax time.Duration // 失败重试间隔上限，默认等于刷新间隔
+}
+
+// ParseDirective 解析拉取相关指令，返回 false 表示不是拉取指令：
+//
+//	refresh_interval|api_interval DURATION
+//	api_timeout DURATION
+//	backoff MIN [MAX]
+//	delta_api URL
+//	long_poll [WAIT]
+func (fc *FetchConfig) ParseDirective(c *caddy.Controller) (bool, error) {
+	name := c.Val()
+	var target []*time.Duration
+	switch name {
+	case "delta_api":
+		if !c.NextArg() {
+			return true, c.ArgErr()
+		}
+		fc.DeltaURL = c.Val()
+		return true, nil
+	case "long_poll":
+		fc.LongPoll = DefaultLongPoll
+		target = []*time.Duration{&fc.LongPoll}
+	case "refresh_interval", "api_interval":
+		target = []*time.Duration{&fc.Interval}
+	case "api_timeout":
+		target = []*time.Duration{&fc.Timeout}
+	case "backoff":
+		target = []*time.Duration{&fc.BackoffMin, &fc.BackoffMax}
+	default:
+		return false, nil
+	}
+	args := c.RemainingArgs()
+	if (len(args) == 0 && name != "long_poll") || len(args) > len(target) {
+		return true, c.ArgErr()
+	}
+	for i, arg := range args {
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return true, c.Errf("invalid %s value: %s", name, arg)
+		}
+		*target[i] = d
+	}
+	if fc.BackoffMax > 0 && fc.BackoffMax < fc.BackoffMin {
+		return true, c.Errf("backoff max %s is less than min %s", fc.BackoffMax, fc.BackoffMin)
+	}
+	return true, nil
+}
+
+// Validate 检查指令之间的依赖关系
+func (fc FetchConfig) Validate() error {
+	if fc.LongPoll > 0 && fc.DeltaURL == "" {
+		return errors.New("long_poll requires delta_api")
+	}
+	return nil
+}
+
+func (fc FetchConfig) interval() time.Duration {
+	if fc.Interval > 0 {
+		return fc.Interval
+	}
+	return DefaultInterval
+}
+
+func (fc FetchConfig) timeout() time.Duration {
+	if fc.Timeout > 0 {
+		return fc.Timeout
+	}
+	return DefaultTimeout
+}
+
+// backoff 第 failures 次连续失败后的重试间隔：从 BackoffMin 开始翻倍，不超过 BackoffMax，
+// 并在 [d/2, d) 范围内随机抖动，避免多个实例同时重试
+func (fc FetchConfig) backoff(failures int) time.Duration {
+	d := fc.BackoffMin
+	if d <= 0 {
+		d = DefaultBackoffMin
+	}
+	max := fc.BackoffMax
+	if max <= 0 {
+		max = fc.interval()
+	}
+	for i := 1; i < failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Fetcher 周期性拉取 HTTP 接口数据，成功后交给 OnData 处理；拉取失败时按指数退避提前重试
+type Fetcher struct {
+	FetchConfig
+	Log    *Logger                 // 插件日志
+	URL    string                  // 接口地址
+	OnData func(body []byte) error // 数据处理回调，返回错误表示数据无效
+	// OnDelta 增量数据处理回调，返回合并后的全量数据用于写入快照；为 nil 时不使用增量接口
+	OnDelta func(body []byte) ([]byte, error)
+	// Observe 每次拉取结束后回调，err 为 nil 表示成功、ErrNotModified 表示数据未变化，用于上报指标
+	Observe func(err error, duration time.Duration)
+	// Snapshot 快照文件路径，非空时每次成功拉取后写入，启动时先加载
+	Snapshot string
+	// OnSnapshot 加载或写入快照后回调，参数为快照时间，用于上报快照年龄
+	OnSnapshot func(modTime time.Time)
+
+	mu           sync.Mutex // 串行化拉取，保护以下状态
+	etag         string
+	lastModified string
+	version      string
+	hash         [sha256.Size]byte // 最近一次加载的全量数据哈希
+
+	statusMu    sync.Mutex // 保护以下拉取状态，与 mu 分开，查看状态时不必等待进行中的请求
+	lastFetch   time.Time
+	lastSuccess time.Time
+	lastErr     error
+	dataVersion string
+	failing     atomic.Bool // 最近一次拉取失败，查询路径无锁读取
+
+	client   *http.Client
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Start 先加载快照（如配置），再同步拉取一次，然后在后台按间隔定期拉取；
+// 配置长轮询时另起协程订阅增量变更，定期拉取作为长轮询中断时的兜底
+func (f *Fetcher) Start() {
+	f.stop = make(chan struct{})
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	f.client = &http.Client{Timeout: f.timeout()}
+	f.loadSnapshot()
+	err := f.Fetch()
+	if f.LongPoll > 0 && f.DeltaURL != "" && f.OnDelta != nil {
+		go f.watch()
+	}
+	go func() {
+		failures := 0
+		timer := time.NewTimer(f.next(err, &failures))
+		defer timer.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-timer.C:
+				err := f.Fetch()
+				timer.Reset(f.next(err, &failures))
+			}
+		}
+	}()
+}
+
+// next 计算下一次拉取的等待时间：成功时按刷新间隔，失败时按退避间隔
+func (f *Fetcher) next(err error, failures *int) time.Duration {
+	if err == nil {
+		*failures = 0
+		return f.interval()
+	}
+	*failures++
+	d := f.backoff(*failures)
+	f.Log.Debugf("fetch failed %d times, retry in %s", *failures, d)
+	return d
+}
+
+// Stop 停止后台拉取
+func (f *Fetcher) Stop() error {
+	f.stopOnce.Do(func() {
+		if f.stop != nil {
+			close(f.stop)
+		}
+		if f.cancel != nil {
+			f.cancel()
+		}
+	})
+	return nil
+}
+
+// Fetch 拉取一次数据，数据未变化时返回 nil
+func (f *Fetcher) Fetch() error {
+	start := time.Now()
+	err := f.fetch()
+	if f.Observe != nil {
+		f.Observe(err, time.Since(start))
+	}
+	if errors.Is(err, ErrNotModified) {
+		return nil
+	}
+	return err
+}
+
+// FetchStatus 拉取状态，用于管理接口展示
+type FetchStatus struct {
+	URL         string    `json:"url"`
+	DeltaURL    string    `json:"delta_url,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	LastFetch   time.Time `json:"last_fetch"`
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Status 当前数据版本与最近一次拉取的时间和结果
+func (f *Fetcher) Status() FetchStatus {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	status := FetchStatus{
+		URL:         f.URL,
+		DeltaURL:    f.DeltaURL,
+		Version:     f.dataVersion,
+		LastFetch:   f.lastFetch,
+		LastSuccess: f.lastSuccess,
+	}
+	if f.lastErr != nil {
+		status.LastError = f.lastErr.Error()
+	}
+	return status
+}
+
+// record 记录一次拉取（含长轮询收到的变更）的结果，数据未变化视为成功；调用方需持有 mu
+func (f *Fetcher) record(err error) {
+	f.statusMu.Lock()
+	defer f.statusMu.Unlock()
+	f.lastFetch, f.dataVersion = time.Now(), f.version
+	if err == nil || errors.Is(err, ErrNotModified) {
+		f.lastSuccess, f.lastErr = f.lastFetch, nil
+		f.failing.Store(false)
+		return
+	}
+	f.lastErr = err
+	f.failing.Store(true)
+}
+
+// Degraded 最近一次拉取失败时返回 true，此时查找表可能已过时；f 为 nil（未配置 API）时返回 false
+func (f *Fetcher) Degraded() bool {
+	return f != nil && f.failing.Load()
+}
+
+// fetch 已知数据版本且配置了增量接口时优先增量拉取，增量失败时回退到全量拉取
+func (f *Fetcher) fetch() (err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer func() { f.record(err) }()
+	if f.DeltaURL != "" && f.OnDelta != nil && f.version != "" {
+		err := f.fetchDelta()
+		if err == nil || errors.Is(err, ErrNotModified) {
+			return err
+		}
+		f.Log.Warningf("%v, falling back to full fetch", err)
+	}
+	return f.fetchFull()
+}
+
+// fetchFull 全量拉取：带上 ETag / Last-Modified 做条件请求，内容哈希未变化时同样跳过重建
+func (f *Fetcher) fetchFull() error {
+	header := make(http.Header)
+	if f.etag != "" {
+		header.Set("If-None-Match", f.etag)
+	}
+	if f.lastModified != "" {
+		header.Set("If-Modified-Since", f.lastModified)
+	}
+	status, respHeader, body, err := f.get(f.client, f.URL, header)
+	if err != nil {
+		f.Log.Warningf("%v", err)
+		return err
+	}
+	if status == http.StatusNotModified {
+		f.remember(respHeader)
+		f.touchSnapshot()
+		return ErrNotModified
+	}
+	sum := sha256.Sum256(body)
+	if sum == f.hash {
+		f.remember(respHeader)
+		f.touchSnapshot()
+		return ErrNotModified
+	}
+	if err := f.OnData(body); err != nil {
+		f.Log.Warningf("unmarshal API json error: %v", err)
+		return err
+	}
+	f.hash = sum
+	f.remember(respHeader)
+	f.saveSnapshot(body)
+	return nil
+}
+
+// fetchDelta 增量拉取自当前版本以来的变更
+func (f *Fetcher) fetchDelta() error {
+	u, err := f.deltaURL(f.version, 0)
+	if err != nil {
+		return err
+	}
+	status, respHeader, body, err := f.get(f.client, u, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotModified {
+		f.touchSnapshot()
+		return ErrNotModified
+	}
+	return f.applyDelta(respHeader, body)
+}
+
+// deltaURL 生成增量接口地址，wait > 0 时请求服务端挂起等待变更
+func (f *Fetcher) deltaURL(since string, wait time.Duration) (string, error) {
+	u, err := url.Parse(f.DeltaURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid delta API url: %w", err)
+	}
+	query := u.Query()
+	query.Set("since", since)
+	if wait > 0 {
+		query.Set("wait", wait.String())
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// applyDelta 应用增量数据并更新版本，调用方需持有 mu
+func (f *Fetcher) applyDelta(header http.Header, body []byte) error {
+	snapshot, err := f.OnDelta(body)
+	if err != nil {
+		return fmt.Errorf("apply delta error: %w", err)
+	}
+	// 增量合并后的数据与全量接口不一定逐字节一致，清空哈希，下次全量拉取时重新加载
+	f.hash = [sha256.Size]byte{}
+	f.version = header.Get(VersionHeader)
+	f.saveSnapshot(snapshot)
+	return nil
+}
+
+// remember 记录全量接口返回的缓存校验信息与数据版本
+func (f *Fetcher) remember(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" {
+		f.etag = etag
+	}
+	if lastModified := header.Get("Last-Modified"); lastModified != "" {
+		f.lastModified = lastModified
+	}
+	if version := header.Get(VersionHeader); version != "" {
+		f.version = version
+	}
+}
+
+// loadSnapshot 启动时加载上次成功拉取的数据，使重启不依赖 API 可用
+func (f *Fetcher) loadSnapshot() {
+	if f.Snapshot == "" {
+		return
+	}
+	body, modTime, err := ReadSnapshot(f.Snapshot)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			f.Log.Warningf("read snapshot %s error: %v", f.Snapshot, err)
+		}
+		return
+	}
+	if err := f.OnData(body); err != nil {
+		f.Log.Warningf("load snapshot %s error: %v", f.Snapshot, err)
+		return
+	}
+	// 全量接口返回相同内容时无需重建
+	f.hash = sha256.Sum256(body)
+	f.Log.Infof("loaded snapshot %s, age %s", f.Snapshot, time.Since(modTime).Truncate(time.Second))
+	if f.OnSnapshot != nil {
+		f.OnSnapshot(modTime)
+	}
+}
+
+// saveSnapshot 将成功加载的数据写入快照文件，写入失败不影响本次热加载
+func (f *Fetcher) saveSnapshot(body []byte) {
+	if f.Snapshot == "" {
+		return
+	}
+	if err := WriteSnapshot(f.Snapshot, body); err != nil {
+		f.Log.Warningf("write snapshot %s error: %v", f.Snapshot, err)
+		return
+	}
+	if f.OnSnapshot != nil {
+		f.OnSnapshot(time.Now())
+	}
+}
+
+// touchSnapshot 数据未变化时更新快照文件的修改时间，使快照年龄反映最近一次成功拉取
+func (f *Fetcher) touchSnapshot() {
+	if f.Snapshot == "" {
+		return
+	}
+	now := time.Now()
+	if err := os.Chtimes(f.Snapshot, now, now); err != nil {
+		f.Log.Warningf("touch snapshot %s error: %v", f.Snapshot, err)
+		return
+	}
+	if f.OnSnapshot != nil {
+		f.OnSnapshot(now)
+	}
+}
+
+// get 发起请求，返回 200 或 304 的响应，其他状态码视为错误；Stop 时取消进行中的请求
+func (f *Fetcher) get(client *http.Client, u string, header http.Header) (int, http.Header, []byte, error) {
+	if client == nil {
+		client = &http.Client{Timeout: f.timeout()}
+	}
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("fetch API error: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("fetch API error: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return resp.StatusCode, resp.Header, nil, nil
+	default:
+		return 0, nil, nil, fmt.Errorf("fetch API %s error: unexpected status %s", u, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("read API body error: %w", err)
+	}
+	return resp.StatusCode, resp.Header, body, nil
+}
@@ -0,0 +1,262 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// testItem 测试用映射条目，K 为主键
+type testItem struct {
+	K string `json:"k"`
+	V string `json:"v"`
+}
+
+func testItemKey(i testItem) string { return i.K }
+
+// newTestSources 创建测试用多来源数据，Build 返回合并后的条目数
+func newTestSources() *Sources[testItem] {
+	return &Sources[testItem]{
+		Name:  "测试",
+		Log:   NewLogger("test"),
+		Key:   testItemKey,
+		Build: func(merged []testItem) int { return len(merged) },
+	}
+}
+
+// apiResponse 测试接口的一次应答
+type apiResponse struct {
+	status  int
+	body    string
+	etag    string
+	version string
+}
+
+// apiServer 按顺序返回预设应答的测试接口，记录每次请求
+type apiServer struct {
+	*httptest.Server
+	mu        sync.Mutex
+	responses []apiResponse
+	requests  []*http.Request
+}
+
+func newAPIServer(t *testing.T, responses ...apiResponse) *apiServer {
+	t.Helper()
+	s := &apiServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests = append(s.requests, r)
+		if len(s.responses) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp := s.responses[0]
+		s.responses = s.responses[1:]
+		if resp.etag != "" {
+			w.Header().Set("ETag", resp.etag)
+		}
+		if resp.version != "" {
+			w.Header().Set(VersionHeader, resp.version)
+		}
+		w.WriteHeader(resp.status)
+		w.Write([]byte(resp.body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// lastRequest 最近一次请求
+func (s *apiServer) lastRequest() *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[len(s.requests)-1]
+}
+
+func TestFetcherConditionalGet(t *testing.T) {
+	const bodyA, bodyB = `[{"k":"a","v":"1"}]`, `[{"k":"a","v":"2"}]`
+	server := newAPIServer(t,
+		apiResponse{status: http.StatusOK, body: bodyA, etag: `"a"`},
+		apiResponse{status: http.StatusNotModified, etag: `"a"`},
+		apiResponse{status: http.StatusOK, body: bodyA, etag: `"b"`},
+		apiResponse{status: http.StatusOK, body: bodyB, etag: `"c"`},
+		apiResponse{status: http.StatusInternalServerError},
+	)
+	loads := 0
+	f := &Fetcher{Log: NewLogger("test"), URL: server.URL, OnData: func([]byte) error { loads++; return nil }}
+	steps := []struct {
+		name         string
+		wantIfNone   string // 请求携带的 If-None-Match
+		wantLoads    int
+		wantErr      bool
+		wantDegraded bool
+	}{
+		{name: "first fetch", wantLoads: 1},
+		{name: "304 skips reload", wantIfNone: `"a"`, wantLoads: 1},
+		{name: "same content skips reload", wantIfNone: `"a"`, wantLoads: 1},
+		{name: "changed content reloads", wantIfNone: `"b"`, wantLoads: 2},
+		{name: "error keeps data", wantIfNone: `"c"`, wantLoads: 2, wantErr: true, wantDegraded: true},
+	}
+	for _, step := range steps {
+		err := f.Fetch()
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: Fetch() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		if got := server.lastRequest().Header.Get("If-None-Match"); got != step.wantIfNone {
+			t.Errorf("%s: If-None-Match = %q, want %q", step.name, got, step.wantIfNone)
+		}
+		if loads != step.wantLoads {
+			t.Errorf("%s: loads = %d, want %d", step.name, loads, step.wantLoads)
+		}
+		if f.Degraded() != step.wantDegraded {
+			t.Errorf("%s: Degraded() = %v, want %v", step.name, f.Degraded(), step.wantDegraded)
+		}
+	}
+}
+
+func TestFetcherDelta(t *testing.T) {
+	full := newAPIServer(t,
+		apiResponse{status: http.StatusOK, body: `[{"k":"a","v":"1"},{"k":"b","v":"1"}]`, version: "1"},
+		apiResponse{status: http.StatusOK, body: `[{"k":"c","v":"1"}]`, version: "9"},
+	)
+	delta := newAPIServer(t,
+		apiResponse{status: http.StatusOK, body: `{"added":[{"k":"b","v":"2"},{"k":"c","v":"1"}],"removed":[{"k":"a"}]}`, version: "2"},
+		apiResponse{status: http.StatusNotModified},
+		apiResponse{status: http.StatusGone},
+	)
+	sources := newTestSources()
+	f := &Fetcher{
+		FetchConfig: FetchConfig{DeltaURL: delta.URL},
+		Log:         NewLogger("test"),
+		URL:         full.URL,
+		OnData:      sources.LoadAPI,
+		OnDelta:     sources.LoadDelta,
+	}
+	steps := []struct {
+		name      string
+		wantSince string // 增量请求的 since 参数，为空表示本次走全量接口
+		want      []testItem
+	}{
+		{name: "full fetch", want: []testItem{{"a", "1"}, {"b", "1"}}},
+		{name: "delta applied", wantSince: "1", want: []testItem{{"b", "2"}, {"c", "1"}}},
+		{name: "delta not modified", wantSince: "2", want: []testItem{{"b", "2"}, {"c", "1"}}},
+		{name: "delta error falls back to full", wantSince: "2", want: []testItem{{"c", "1"}}},
+	}
+	for i, step := range steps {
+		if err := f.Fetch(); err != nil {
+			t.Fatalf("%s: Fetch() error = %v", step.name, err)
+		}
+		if step.wantSince != "" {
+			if len(delta.requests) == 0 {
+				t.Fatalf("%s: delta API not called", step.name)
+			}
+			if got := delta.lastRequest().URL.Query().Get("since"); got != step.wantSince {
+				t.Errorf("%s: since = %q, want %q", step.name, got, step.wantSince)
+			}
+		} else if i == 0 && len(delta.requests) != 0 {
+			t.Errorf("%s: delta API called before version known", step.name)
+		}
+		if got := sources.Merged(); !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: merged = %v, want %v", step.name, got, step.want)
+		}
+	}
+	if got := f.Status().Version; got != "9" {
+		t.Errorf("version after full fallback = %q, want 9", got)
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []testItem{{"a", "1"}, {"b", "1"}}
+	tests := []struct {
+		name  string
+		delta Delta[testItem]
+		want  []testItem
+	}{
+		{name: "empty", want: base},
+		{name: "add", delta: Delta[testItem]{Added: []testItem{{"c", "1"}}}, want: []testItem{{"a", "1"}, {"b", "1"}, {"c", "1"}}},
+		{name: "update in place", delta: Delta[testItem]{Added: []testItem{{"a", "2"}}}, want: []testItem{{"a", "2"}, {"b", "1"}}},
+		{name: "remove by key", delta: Delta[testItem]{Removed: []testItem{{K: "a"}}}, want: []testItem{{"b", "1"}}},
+		{name: "remove and re-add", delta: Delta[testItem]{Added: []testItem{{"a", "3"}}, Removed: []testItem{{K: "a"}}}, want: []testItem{{"b", "1"}, {"a", "3"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ApplyDelta(base, testItemKey, tt.delta)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ApplyDelta() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(base, []testItem{{"a", "1"}, {"b", "1"}}) {
+				t.Errorf("ApplyDelta() modified base: %v", base)
+			}
+		})
+	}
+}
+
+func TestFetcherSnapshot(t *testing.T) {
+	snapshotBody := `[{"k":"s","v":"1"}]`
+	tests := []struct {
+		name         string
+		snapshot     bool // 启动前已有快照
+		api          apiResponse
+		want         []testItem
+		wantSnapshot string // 拉取后快照文件内容
+		wantDegraded bool
+	}{
+		{
+			name: "api down uses snapshot", snapshot: true, api: apiResponse{status: http.StatusInternalServerError},
+			want: []testItem{{"s", "1"}}, wantSnapshot: snapshotBody, wantDegraded: true,
+		},
+		{
+			name: "api overrides snapshot", snapshot: true, api: apiResponse{status: http.StatusOK, body: `[{"k":"a","v":"1"}]`},
+			want: []testItem{{"a", "1"}}, wantSnapshot: `[{"k":"a","v":"1"}]`,
+		},
+		{
+			name: "same content as snapshot", snapshot: true, api: apiResponse{status: http.StatusOK, body: snapshotBody},
+			want: []testItem{{"s", "1"}}, wantSnapshot: snapshotBody,
+		},
+		{
+			name: "no snapshot and api down", api: apiResponse{status: http.StatusInternalServerError},
+			wantDegraded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "snapshot.json")
+			if tt.snapshot {
+				if err := WriteSnapshot(path, []byte(snapshotBody)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			server := newAPIServer(t, tt.api)
+			sources := newTestSources()
+			f := &Fetcher{Log: NewLogger("test"), URL: server.URL, OnData: sources.LoadAPI, Snapshot: path}
+			f.loadSnapshot()
+			f.Fetch()
+			if got := sources.Merged(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged = %v, want %v", got, tt.want)
+			}
+			if f.Degraded() != tt.wantDegraded {
+				t.Errorf("Degraded() = %v, want %v", f.Degraded(), tt.wantDegraded)
+			}
+			body, _, err := ReadSnapshot(path)
+			if tt.wantSnapshot == "" {
+				if err == nil {
+					t.Errorf("snapshot written: %s", body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got, want []testItem
+			json.Unmarshal(body, &got)
+			json.Unmarshal([]byte(tt.wantSnapshot), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("snapshot = %s, want %s", body, tt.wantSnapshot)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// 健康检查协议
+const (
+	ProbeTCP  = "tcp"
+	ProbeHTTP = "http"
+	ProbeDNS  = "dns"
+)
+
+// 健康检查默认参数
+const (
+	DefaultHealthInterval = 10 * time.Second
+	DefaultHealthTimeout  = 2 * time.Second
+	DefaultHealthFails    = 2
+
+	// healthIdleRounds 目标连续多少个检查周期未被查询到时停止探测
+	healthIdleRounds = 10
+)
+
+// HealthRule 健康检查规则，作用于 Zone 及其子域名下的 A/AAAA 记录
+type HealthRule struct {
+	Zone     string        // 名称或区域，"." 匹配全部
+	Proto    string        // tcp / http / dns
+	Port     int           // 探测端口
+	Path     string        // http 探测路径
+	Interval time.Duration // 探测间隔
+	Timeout  time.Duration // 单次探测超时
+}
+
+// ParseHealthRule 解析 Corefile 参数：NAME PROTO PORT[/PATH] [INTERVAL [TIMEOUT]]
+func ParseHealthRule(args []string) (HealthRule, error) {
+	if len(args) < 3 || len(args) > 5 {
+		return HealthRule{}, fmt.Errorf("usage: healthcheck NAME tcp|http|dns PORT[/PATH] [INTERVAL [TIMEOUT]]")
+	}
+	rule := HealthRule{
+		Zone:     strings.ToLower(dns.Fqdn(args[0])),
+		Proto:    args[1],
+		Interval: DefaultHealthInterval,
+		Timeout:  DefaultHealthTimeout,
+	}
+	switch rule.Proto {
+	case ProbeTCP, ProbeHTTP, ProbeDNS:
+	default:
+		return HealthRule{}, fmt.Errorf("unknown health check protocol: %s", rule.Proto)
+	}
+	port, path, _ := strings.Cut(args[2], "/")
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return HealthRule{}, fmt.Errorf("invalid health check port: %s", args[2])
+	}
+	rule.Port = p
+	if rule.Proto == ProbeHTTP {
+		rule.Path = "/" + path
+	}
+	if len(args) > 3 {
+		if rule.Interval, err = time.ParseDuration(args[3]); err != nil || rule.Interval <= 0 {
+			return HealthRule{}, fmt.Errorf("invalid health check interval: %s", args[3])
+		}
+	}
+	if len(args) > 4 {
+		if rule.Timeout, err = time.ParseDuration(args[4]); err != nil || rule.Timeout <= 0 {
+			return HealthRule{}, fmt.Errorf("invalid health check timeout: %s", args[4])
+		}
+	}
+	return rule, nil
+}
+
+// HealthChecker 对解析结果中的后端IP做主动健康检查。
+// 目标在首次被查询时注册并视为健康，之后在后台按规则周期探测；长时间未被查询的目标自动停止探测
+type HealthChecker struct {
+	Log   *Logger // 插件日志
+	Rules []HealthRule
+	Fails int // 连续失败多少次判为不健康
+
+	mu      sync.Mutex
+	targets map[string]*healthTarget
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// healthTarget 单个 规则+IP 的探测状态
+type healthTarget struct {
+	rule     *HealthRule
+	addr     netip.Addr
+	healthy  atomic.Bool
+	lastSeen atomic.Int64
+}
+
+// ParseDirective 解析健康检查指令，可重复配置多条规则，返回 false 表示不是该指令：
+//
+//	healthcheck NAME tcp|http|dns PORT[/PATH] [INTERVAL [TIMEOUT]]
+func (h *HealthChecker) ParseDirective(c *caddy.Controller) (bool, error) {
+	if c.Val() != "healthcheck" {
+		return false, nil
+	}
+	rule, err := ParseHealthRule(c.RemainingArgs())
+	if err != nil {
+		return true, c.Errf("invalid healthcheck: %v", err)
+	}
+	h.Rules = append(h.Rules, rule)
+	return true, nil
+}
+
+// Enabled 是否配置了健康检查规则
+func (h *HealthChecker) Enabled() bool {
+	return h != nil && len(h.Rules) > 0
+}
+
+// Start 初始化检查器
+func (h *HealthChecker) Start() {
+	if h.Fails <= 0 {
+		h.Fails = DefaultHealthFails
+	}
+	h.targets = make(map[string]*healthTarget)
+	h.stop = make(chan struct{})
+}
+
+// Stop 停止所有探测
+func (h *HealthChecker) Stop() error {
+	h.once.Do(func() {
+		if h.stop != nil {
+			close(h.stop)
+		}
+	})
+	return nil
+}
+
+// Filter 去除不健康的 A/AAAA 记录，其他类型原样保留；
+// 若全部地址记录都不健康则原样返回，避免解析结果为空
+func (h *HealthChecker) Filter(rrs []dns.RR) []dns.RR {
+	if !h.Enabled() {
+		return rrs
+	}
+	out := make([]dns.RR, 0, len(rrs))
+	var down []netip.Addr
+	healthy := 0
+	for _, rr := range rrs {
+		ip, ok := RRAddr(rr)
+		if !ok {
+			out = append(out, rr)
+			continue
+		}
+		if !h.Healthy(rr.Header().Name, ip) {
+			down = append(down, ip)
+			continue
+		}
+		healthy++
+		out = append(out, rr)
+	}
+	if len(down) == 0 {
+		return rrs
+	}
+	if healthy == 0 {
+		h.Log.Debugf("all backends unhealthy %v, returning all", down)
+		return rrs
+	}
+	h.Log.Debugf("dropped unhealthy backends: %v", down)
+	return out
+}
+
+// Healthy 判断名称下的后端IP是否健康，没有匹配规则时视为健康
+func (h *HealthChecker) Healthy(name string, addr netip.Addr) bool {
+	if h == nil {
+		return true
+	}
+	rule := h.match(strings.ToLower(name))
+	if rule == nil {
+		return true
+	}
+	key := rule.Proto + "|" + netip.AddrPortFrom(addr, uint16(rule.Port)).String() + rule.Path
+
+	h.mu.Lock()
+	t, ok := h.targets[key]
+	if !ok {
+		t = &healthTarget{rule: rule, addr: addr}
+		t.healthy.Store(true)
+		h.targets[key] = t
+		go h.run(key, t)
+	}
+	h.mu.Unlock()
+	t.lastSeen.Store(time.Now().UnixNano())
+	return t.healthy.Load()
+}
+
+// match 返回匹配名称的最精确规则
+func (h *HealthChecker) match(name string) *HealthRule {
+	var best *HealthRule
+	for i := range h.Rules {
+		r := &h.Rules[i]
+		if dns.IsSubDomain(r.Zone, name) && (best == nil || len(r.Zone) > len(best.Zone)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// run 周期探测单个目标，长时间未被查询时退出
+func (h *HealthChecker) run(key string, t *healthTarget) {
+	ticker := time.NewTicker(t.rule.Interval)
+	defer ticker.Stop()
+	fails := 0
+	for {
+		if err := Probe(t.rule, t.addr); err != nil {
+			fails++
+			if fails >= h.Fails && t.healthy.Swap(false) {
+				h.Log.Warningf("backend %s marked unhealthy: %v", t.addr, err)
+			}
+		} else {
+			fails = 0
+			if !t.healthy.Swap(true) {
+				h.Log.Infof("backend %s recovered", t.addr)
+			}
+		}
+
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, t.lastSeen.Load())) > healthIdleRounds*t.rule.Interval {
+			h.mu.Lock()
+			delete(h.targets, key)
+			h.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Probe 按规则对地址执行一次探测
+func Probe(rule *HealthRule, addr netip.Addr) error {
+	hostport := netip.AddrPortFrom(addr, uint16(rule.Port)).String()
+	switch rule.Proto {
+	case ProbeTCP:
+		conn, err := net.DialTimeout("tcp", hostport, rule.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ProbeHTTP:
+		client := &http.Client{Timeout: rule.Timeout}
+		resp, err := client.Get("http://" + hostport + rule.Path)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	case ProbeDNS:
+		m := new(dns.Msg)
+		m.SetQuestion(".", dns.TypeNS)
+		client := &dns.Client{Timeout: rule.Timeout}
+		resp, _, err := client.Exchange(m, hostport)
+		if err != nil {
+			return err
+		}
+		if resp.Rcode == dns.RcodeServerFailure {
+			return fmt.Errorf("rcode %s", dns.RcodeToString[resp.Rcode])
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown health check protocol: %s", rule.Proto)
+}
@@ -0,0 +1,224 @@
+package common
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// addrPort 解析监听地址
+func addrPort(t *testing.T, addr string) (netip.Addr, int) {
+	t.Helper()
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ap.Addr(), int(ap.Port())
+}
+
+// closedPort 返回一个当前没有监听的本地 TCP 端口
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port := addrPort(t, l.Addr().String())
+	l.Close()
+	return port
+}
+
+// startDNS 在 127.0.0.1 启动 UDP DNS 服务，rcode 为应答的 rcode
+func startDNS(t *testing.T, rcode *atomic.Int32) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		NotifyStartedFunc: func() { close(started) },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetRcode(r, int(rcode.Load()))
+			w.WriteMsg(m)
+		}),
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+// eventually 在 2s 内轮询直到 cond 成立
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	tcpAddr, tcpPort := addrPort(t, l.Addr().String())
+
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer hs.Close()
+	httpAddr, httpPort := addrPort(t, hs.Listener.Addr().String())
+
+	var rcode atomic.Int32
+	dnsAddr, dnsPort := addrPort(t, startDNS(t, &rcode))
+
+	timeout := 500 * time.Millisecond
+	tests := []struct {
+		name    string
+		rule    HealthRule
+		addr    netip.Addr
+		setup   func()
+		healthy bool
+	}{
+		{"tcp listening", HealthRule{Proto: ProbeTCP, Port: tcpPort, Timeout: timeout}, tcpAddr, nil, true},
+		{"tcp closed", HealthRule{Proto: ProbeTCP, Port: closedPort(t), Timeout: timeout}, tcpAddr, nil, false},
+		{"http ok", HealthRule{Proto: ProbeHTTP, Port: httpPort, Path: "/healthz", Timeout: timeout}, httpAddr, nil, true},
+		{"http wrong path", HealthRule{Proto: ProbeHTTP, Port: httpPort, Path: "/", Timeout: timeout}, httpAddr, nil, false},
+		{"http 503", HealthRule{Proto: ProbeHTTP, Port: httpPort, Path: "/healthz", Timeout: timeout}, httpAddr,
+			func() { status.Store(http.StatusServiceUnavailable) }, false},
+		{"dns noerror", HealthRule{Proto: ProbeDNS, Port: dnsPort, Timeout: timeout}, dnsAddr, nil, true},
+		{"dns nxdomain", HealthRule{Proto: ProbeDNS, Port: dnsPort, Timeout: timeout}, dnsAddr,
+			func() { rcode.Store(dns.RcodeNameError) }, true},
+		{"dns servfail", HealthRule{Proto: ProbeDNS, Port: dnsPort, Timeout: timeout}, dnsAddr,
+			func() { rcode.Store(dns.RcodeServerFailure) }, false},
+	}
+	for _, tc := range tests {
+		if tc.setup != nil {
+			tc.setup()
+		}
+		err := Probe(&tc.rule, tc.addr)
+		if (err == nil) != tc.healthy {
+			t.Errorf("%s: Probe() error = %v, want healthy %v", tc.name, err, tc.healthy)
+		}
+	}
+}
+
+func TestHealthCheckerFilter(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	up, port := addrPort(t, l.Addr().String())
+	// 127.0.0.2 同一端口没有监听
+	down := netip.MustParseAddr("127.0.0.2")
+
+	h := &HealthChecker{
+		Log:   NewLogger("test"),
+		Rules: []HealthRule{{Zone: "example.org.", Proto: ProbeTCP, Port: port, Interval: 10 * time.Millisecond, Timeout: 200 * time.Millisecond}},
+		Fails: 1,
+	}
+	h.Start()
+	defer h.Stop()
+
+	rrs := aRecords(t, "www.example.org.", 60, up.String(), down.String())
+	// 首次查询时目标视为健康
+	if got := h.Filter(rrs); len(got) != 2 {
+		t.Fatalf("new targets should be healthy, got %v", got)
+	}
+	eventually(t, "unhealthy backend not filtered", func() bool { return len(h.Filter(rrs)) == 1 })
+	if got := h.Filter(rrs); got[0].(*dns.A).A.String() != up.String() {
+		t.Errorf("Filter() = %v, want only %s", got, up)
+	}
+
+	// 不匹配规则的名称不做检查
+	other := aRecords(t, "www.example.com.", 60, down.String())
+	if got := h.Filter(other); len(got) != 1 {
+		t.Errorf("names without a rule should pass through, got %v", got)
+	}
+
+	// 全部不健康时原样返回
+	all := aRecords(t, "api.example.org.", 60, down.String(), "127.0.0.3")
+	h.Filter(all)
+	eventually(t, "backends not marked unhealthy", func() bool {
+		return !h.Healthy("api.example.org.", down) && !h.Healthy("api.example.org.", netip.MustParseAddr("127.0.0.3"))
+	})
+	if got := h.Filter(all); len(got) != 2 {
+		t.Errorf("all unhealthy should return all records, got %v", got)
+	}
+}
+
+// TestHealthCheckerThresholds 连续失败 Fails 次才判为不健康，一次成功即恢复。
+// HTTP 服务每收到一次探测先通知测试、再等待测试给出状态码，探测逐次推进，结果确定
+func TestHealthCheckerThresholds(t *testing.T) {
+	arrived := make(chan struct{})
+	statuses := make(chan int)
+	done := make(chan struct{})
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case arrived <- struct{}{}:
+		case <-done:
+			return
+		}
+		select {
+		case code := <-statuses:
+			w.WriteHeader(code)
+		case <-done:
+		}
+	}))
+	defer hs.Close()
+	defer close(done)
+	addr, port := addrPort(t, hs.Listener.Addr().String())
+
+	h := &HealthChecker{
+		Log:   NewLogger("test"),
+		Rules: []HealthRule{{Zone: ".", Proto: ProbeHTTP, Port: port, Path: "/", Interval: time.Millisecond, Timeout: 5 * time.Second}},
+		Fails: 3,
+	}
+	h.Start()
+	defer h.Stop()
+
+	if !h.Healthy("www.example.org.", addr) {
+		t.Fatal("new target should be healthy")
+	}
+	<-arrived // 第 1 次探测
+	steps := []struct {
+		code    int
+		healthy bool
+	}{
+		{http.StatusInternalServerError, true},  // 失败 1 次
+		{http.StatusInternalServerError, true},  // 失败 2 次
+		{http.StatusInternalServerError, false}, // 失败 3 次，判为不健康
+		{http.StatusInternalServerError, false},
+		{http.StatusOK, true}, // 一次成功即恢复
+		{http.StatusInternalServerError, true},
+	}
+	for i, step := range steps {
+		statuses <- step.code
+		// 下一次探测到达时本次探测的结果已经生效
+		<-arrived
+		if got := h.Healthy("www.example.org.", addr); got != step.healthy {
+			t.Fatalf("after probe %d (%d): healthy = %v, want %v", i+1, step.code, got, step.healthy)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package common
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// aRecords 构造一组 name 的 A 记录，TTL 为 ttl
+func aRecords(t *testing.T, name string, ttl int, ips ...string) []dns.RR {
+	t.Helper()
+	var rrs []dns.RR
+	for _, ip := range ips {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN A %s", name, ttl, ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/coredns/caddy"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
+)
+
+// 日志级别
+const (
+	LevelError = iota
+	LevelWarning
+	LevelInfo
+	LevelDebug
+)
+
+// Logger 基于 CoreDNS clog 的插件日志，支持按插件设置级别、逐请求日志采样和结构化决策日志
+type Logger struct {
+	clog.P
+
+	Level     int    // 日志级别，默认 info
+	Sample    uint64 // 逐请求日志每 Sample 条输出 1 条，<= 1 表示不采样
+	Decisions bool   // 是否输出结构化决策日志
+
+	counter atomic.Uint64
+}
+
+// Decision 单次查询的路由决策，以 JSON 输出便于排查
+type Decision struct {
+	Client  string            `json:"client"`
+	ECS     bool              `json:"ecs,omitempty"`
+	Qname   string            `json:"qname"`
+	Qtype   string            `json:"qtype"`
+	Tier    string            `json:"tier"`
+	Answers []string          `json:"answers"`
+	Attrs   map[string]string `json:"attrs,omitempty"`
+}
+
+// NewLogger 创建插件日志
+func NewLogger(plugin string) *Logger {
+	return &Logger{P: clog.NewWithPlugin(plugin), Level: LevelInfo}
+}
+
+// ParseLogLevel 解析日志级别
+func ParseLogLevel(s string) (int, error) {
+	switch s {
+	case "error":
+		return LevelError, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "info":
+		return LevelInfo, nil
+	case "debug":
+		return LevelDebug, nil
+	}
+	return 0, fmt.Errorf("unknown log level: %s", s)
+}
+
+// ParseDirective 解析日志相关指令，返回 false 表示不是日志指令：
+//
+//	log_level error|warning|info|debug
+//	debug
+//	log_sample N
+//	decision_log
+func (l *Logger) ParseDirective(c *caddy.Controller) (bool, error) {
+	switch c.Val() {
+	case "log_level":
+		if !c.NextArg() {
+			return true, c.ArgErr()
+		}
+		level, err := ParseLogLevel(c.Val())
+		if err != nil {
+			return true, c.Errf("invalid log_level value: %s", c.Val())
+		}
+		l.Level = level
+	case "debug":
+		l.Level = LevelDebug
+	case "log_sample":
+		if !c.NextArg() {
+			return true, c.ArgErr()
+		}
+		n, err := strconv.ParseUint(c.Val(), 10, 64)
+		if err != nil || n == 0 {
+			return true, c.Errf("invalid log_sample value: %s", c.Val())
+		}
+		l.Sample = n
+	case "decision_log":
+		l.Decisions = true
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// DebugEnabled 是否输出 debug 日志：插件级别为 debug，且 clog 的 debug 输出已开启（Corefile 中启用了 debug 插件）
+func (l *Logger) DebugEnabled() bool {
+	return l.Level >= LevelDebug && clog.D.Value()
+}
+
+// Sampled 判断本次查询是否输出逐请求日志，每次查询调用一次；
+// 未开启 debug 与决策日志时直接返回 false，不产生额外开销
+func (l *Logger) Sampled() bool {
+	if !l.Decisions && !l.DebugEnabled() {
+		return false
+	}
+	if l.Sample <= 1 {
+		return true
+	}
+	return l.counter.Add(1)%l.Sample == 1
+}
+
+// Debugf 输出 debug 日志
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	if l.Level >= LevelDebug {
+		l.P.Debugf(format, v...)
+	}
+}
+
+// Infof 输出 info 日志
+func (l *Logger) Infof(format string, v ...interface{}) {
+	if l.Level >= LevelInfo {
+		l.P.Infof(format, v...)
+	}
+}
+
+// Warningf 输出 warning 日志
+func (l *Logger) Warningf(format string, v ...interface{}) {
+	if l.Level >= LevelWarning {
+		l.P.Warningf(format, v...)
+	}
+}
+
+// Decision 输出结构化决策日志
+func (l *Logger) Decision(d Decision) {
+	if !l.Decisions {
+		return
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	l.P.Infof("decision %s", b)
+}
+
+// NewDecision 根据请求与返回记录构造决策日志
+func NewDecision(client netip.Addr, ecs bool, r *dns.Msg, tier string, answers []dns.RR) Decision {
+	d := Decision{Client: client.String(), ECS: ecs, Tier: tier}
+	if len(r.Question) > 0 {
+		d.Qname = r.Question[0].Name
+		d.Qtype = dns.TypeToString[r.Question[0].Qtype]
+	}
+	for _, ip := range Addrs(answers) {
+		d.Answers = append(d.Answers, ip.String())
+	}
+	return d
+}
+
+// Addrs 提取记录中的 A/AAAA 地址，用于日志输出
+func Addrs(rrs []dns.RR) []netip.Addr {
+	var ips []netip.Addr
+	for _, rr := range rrs {
+		if ip, ok := RRAddr(rr); ok {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,235 @@
+package common
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/miekg/dns"
+)
+
+// 选择模式
+const (
+	SelectNone               = ""                     // 不做选择，原样返回
+	SelectRandom             = "random"               // 随机打乱顺序，忽略权重
+	SelectRoundRobin         = "round_robin"          // 按名称轮转起始记录，忽略权重
+	SelectWeightedRandom     = "weighted_random"      // 按权重随机抽取（不放回）
+	SelectWeightedRoundRobin = "weighted_round_robin" // 按名称做平滑加权轮询
+	SelectRendezvous         = "rendezvous"           // 按客户端做加权 rendezvous 哈希，同一客户端固定选中相同记录
+)
+
+// wrrStateSize 轮询状态最多保留的名称数
+const wrrStateSize = 4096
+
+// ParseSelectMode 校验选择模式
+func ParseSelectMode(s string) (string, error) {
+	switch s {
+	case SelectRandom, SelectRoundRobin, SelectWeightedRandom, SelectWeightedRoundRobin, SelectRendezvous:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown selection mode: %s", s)
+}
+
+// Selector 按权重从候选记录中选出子集，选中顺序即返回顺序
+type Selector struct {
+	Mode string
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	state *lru.Cache // key -> *wrrState（加权轮询）或 *uint64（轮询）
+}
+
+// wrrState 平滑加权轮询（与 nginx 相同算法）的当前权重，按记录标识（rrID）索引
+type wrrState struct {
+	current map[string]int
+}
+
+// NewSelector 创建选择器
+func NewSelector(mode string) *Selector {
+	state, _ := lru.New(wrrStateSize)
+	return &Selector{
+		Mode:  mode,
+		rng:   rand.New(rand.NewSource(rand.Int63())),
+		state: state,
+	}
+}
+
+// Select 从 rrs 中按权重选出最多 n 条记录（n <= 0 表示全部，仅调整顺序）。
+// weights 与 rrs 一一对应，<= 0 的权重按 1 处理；key 用于区分加权轮询的状态，一般为查询名称与类型，
+// rendezvous 模式下为客户端标识（客户端地址或 ECS 网段）
+func (s *Selector) Select(key string, rrs []dns.RR, weights []int, n int) []dns.RR {
+	if s == nil || len(rrs) == 0 {
+		return rrs
+	}
+	if n <= 0 || n > len(rrs) {
+		n = len(rrs)
+	}
+	w := make([]int, len(rrs))
+	for i := range rrs {
+		w[i] = 1
+		if i < len(weights) && weights[i] > 0 && s.Mode != SelectRandom {
+			w[i] = weights[i]
+		}
+	}
+	switch s.Mode {
+	case SelectRandom:
+		return s.weightedRandom(rrs, w, n)
+	case SelectRoundRobin:
+		return s.roundRobin(key, rrs, n)
+	case SelectWeightedRandom:
+		return s.weightedRandom(rrs, w, n)
+	case SelectWeightedRoundRobin:
+		return s.weightedRoundRobin(key, rrs, w, n)
+	case SelectRendezvous:
+		return rendezvous(key, rrs, w, n)
+	}
+	return rrs[:n]
+}
+
+// weightedRandom 加权随机抽样（Efraimidis-Spirakis）：key = u^(1/w)，取最大的 n 个
+func (s *Selector) weightedRandom(rrs []dns.RR, w []int, n int) []dns.RR {
+	keys := make([]float64, len(rrs))
+	s.mu.Lock()
+	for i := range rrs {
+		keys[i] = math.Pow(s.rng.Float64(), 1/float64(w[i]))
+	}
+	s.mu.Unlock()
+
+	picked := make([]bool, len(rrs))
+	out := make([]dns.RR, 0, n)
+	for len(out) < n {
+		best := -1
+		for i := range rrs {
+			if !picked[i] && (best < 0 || keys[i] > keys[best]) {
+				best = i
+			}
+		}
+		picked[best] = true
+		out = append(out, rrs[best])
+	}
+	return out
+}
+
+// roundRobin 每次查询起始记录后移一位，记录集合变化时按新的长度取模继续轮转
+func (s *Selector) roundRobin(key string, rrs []dns.RR, n int) []dns.RR {
+	s.mu.Lock()
+	var next uint64
+	if v, ok := s.state.Get(key); ok {
+		if p, ok := v.(*uint64); ok {
+			next = *p
+		}
+	}
+	count := next + 1
+	s.state.Add(key, &count)
+	s.mu.Unlock()
+
+	start := int(next % uint64(len(rrs)))
+	out := make([]dns.RR, 0, n)
+	for i := 0; i < n; i++ {
+		out = append(out, rrs[(start+i)%len(rrs)])
+	}
+	return out
+}
+
+// weightedRoundRobin 平滑加权轮询：每次查询所有记录的当前权重加上各自权重，
+// 当前权重最大者排在首位并减去总权重；其余记录按当前权重从大到小排列，即下次最可能被选中的排在前面
+func (s *Selector) weightedRoundRobin(key string, rrs []dns.RR, w []int, n int) []dns.RR {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var st *wrrState
+	if v, ok := s.state.Get(key); ok {
+		st = v.(*wrrState)
+	} else {
+		st = &wrrState{current: make(map[string]int)}
+		s.state.Add(key, st)
+	}
+	// 只保留当前记录的状态，已移除的记录随之丢弃
+	current := make(map[string]int, len(rrs))
+	cur := make([]int, len(rrs))
+	total, best := 0, 0
+	for i, rr := range rrs {
+		id := rrID(rr)
+		cur[i] = st.current[id] + w[i]
+		total += w[i]
+		if cur[i] > cur[best] {
+			best = i
+		}
+		current[id] = cur[i]
+	}
+	cur[best] -= total
+	current[rrID(rrs[best])] = cur[best]
+	st.current = current
+
+	order := make([]int, len(rrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		if order[i] == best || order[j] == best {
+			return order[i] == best
+		}
+		return cur[order[i]] > cur[order[j]]
+	})
+	out := make([]dns.RR, 0, n)
+	for _, i := range order[:n] {
+		out = append(out, rrs[i])
+	}
+	return out
+}
+
+// rrID 记录标识：名称、类型与地址，不含 TTL（经过 forward、cache 后 TTL 每次查询都在递减）
+func rrID(rr dns.RR) string {
+	hdr := rr.Header()
+	if ip, ok := RRAddr(rr); ok {
+		return hdr.Name + "/" + dns.TypeToString[hdr.Rrtype] + "/" + ip.String()
+	}
+	c := dns.Copy(rr)
+	c.Header().Ttl = 0
+	return c.String()
+}
+
+// rendezvous 加权 rendezvous 哈希（HRW）：每条记录得分 = -w / ln(h)，h 为 客户端标识+记录地址 的哈希映射到 (0,1)，
+// 取得分最高的 n 个。结果只取决于客户端与记录集合，增删一个后端只影响原本选中它的客户端
+func rendezvous(key string, rrs []dns.RR, w []int, n int) []dns.RR {
+	scores := make([]float64, len(rrs))
+	for i, rr := range rrs {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		if ip, ok := RRAddr(rr); ok {
+			h.Write(ip.AsSlice())
+		} else {
+			h.Write([]byte(rrID(rr)))
+		}
+		// FNV 对相近输入的高位扩散不足，先做一次 splitmix64 混合，再取高 53 位映射到 (0,1)，避免 ln(0)
+		u := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		scores[i] = -float64(w[i]) / math.Log(u)
+	}
+	order := make([]int, len(rrs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+	out := make([]dns.RR, 0, n)
+	for _, i := range order[:n] {
+		out = append(out, rrs[i])
+	}
+	return out
+}
+
+// mix64 splitmix64 的最终混合函数
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
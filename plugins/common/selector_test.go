@@ -0,0 +1,34 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestWeightedRoundRobinDecreasingTTL(t *testing.T) {
+	s := NewSelector(SelectWeightedRoundRobin)
+	counts := map[string]int{}
+	// 模拟 forward/cache 之后每次查询 TTL 递减
+	for i := 0; i < 30; i++ {
+		rrs := aRecords(t, "www.example.org.", 300-i, "10.0.0.1", "10.0.0.2")
+		out := s.Select("www.example.org./A", rrs, []int{1, 1}, 1)
+		counts[out[0].(*dns.A).A.String()]++
+	}
+	if counts["10.0.0.1"] != 15 || counts["10.0.0.2"] != 15 {
+		t.Errorf("equal weights should alternate, got %v", counts)
+	}
+}
+
+func TestWeightedRoundRobinWeights(t *testing.T) {
+	s := NewSelector(SelectWeightedRoundRobin)
+	counts := map[string]int{}
+	for i := 0; i < 40; i++ {
+		rrs := aRecords(t, "www.example.org.", 60-i, "10.0.0.1", "10.0.0.2")
+		out := s.Select("www.example.org./A", rrs, []int{3, 1}, 1)
+		counts[out[0].(*dns.A).A.String()]++
+	}
+	if counts["10.0.0.1"] != 30 || counts["10.0.0.2"] != 10 {
+		t.Errorf("weights 3:1 should split 30/10, got %v", counts)
+	}
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteSnapshot 原子写入快照文件：先写入同目录临时文件，再重命名覆盖，避免进程中断时留下不完整的文件
+func WriteSnapshot(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadSnapshot 读取快照文件，同时返回文件修改时间（即最近一次成功拉取的时间）
+func ReadSnapshot(path string) ([]byte, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return data, info.ModTime(), nil
+}
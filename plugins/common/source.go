@@ -0,0 +1,313 @@
+package common
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 本地映射文件格式
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatCSV  = "csv"
+)
+
+// DefaultWatchInterval 默认文件检查间隔
+const DefaultWatchInterval = 5 * time.Second
+
+// FormatOf 根据文件扩展名判断格式，无法识别时按 JSON 处理
+func FormatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".csv":
+		return FormatCSV
+	}
+	return FormatJSON
+}
+
+// Decode 按格式解析 JSON/YAML 数据到 v。YAML 先转换为 JSON 再解析，复用结构体上的 json 标签
+func Decode(format string, data []byte, v interface{}) error {
+	switch format {
+	case FormatJSON:
+		return json.Unmarshal(data, v)
+	case FormatYAML:
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		b, err := json.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(b, v)
+	}
+	return fmt.Errorf("unsupported format: %s", format)
+}
+
+// ReadCSV 解析带表头的 CSV，每行返回 列名->值；以 # 开头的行为注释
+func ReadCSV(data []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	header := rows[0]
+	records := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		record := make(map[string]string, len(header))
+		for i, v := range row {
+			if i < len(header) && v != "" {
+				record[strings.TrimSpace(header[i])] = strings.TrimSpace(v)
+			}
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ParseList 解析 JSON/YAML/CSV 格式的映射列表，CSV 需要表头，每行由 fromRecord 转换为条目
+func ParseList[T any](format string, body []byte, fromRecord func(record map[string]string) (T, error)) ([]T, error) {
+	if format != FormatCSV {
+		var items []T
+		err := Decode(format, body, &items)
+		return items, err
+	}
+	records, err := ReadCSV(body)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]T, 0, len(records))
+	for i, record := range records {
+		item, err := fromRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %v", i+2, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// 映射数据来源，优先级依次升高：同一主键以 Corefile 内联 > 本地文件 > API 为准
+const (
+	SourceAPI = iota
+	SourceFile
+	SourceInline
+	sourceCount
+)
+
+var sourceNames = [sourceCount]string{"API", "file", "inline"}
+
+// Sources 三个插件共用的多来源映射数据：任一来源更新时按优先级合并所有来源，由 Build 在旁路重建查找表。
+// 各来源的热加载在锁内串行执行，不影响查询
+type Sources[T any] struct {
+	Name       string                                    // 日志中的数据名称，如 "内网网段"
+	Log        *Logger                                   // 插件日志
+	Key        func(T) string                            // 条目主键，相同主键时高优先级来源覆盖低优先级来源
+	Format     string                                    // 本地文件格式，见 FormatOf
+	FromRecord func(record map[string]string) (T, error) // 本地 CSV 文件的一行转换为条目
+	Build      func(merged []T) int                      // 用合并后的条目重建查找表，返回生效的网段数
+
+	mu     sync.Mutex
+	layers [sourceCount][]T
+	merged []T
+}
+
+// Set 更新某一来源的数据，合并所有来源后重建查找表
+func (s *Sources[T]) Set(source int, items []T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.layers[source] = items
+	s.merged = Merge(s.Key, s.layers[:]...)
+	count := s.Build(s.merged)
+	s.Log.Infof("%s %s已热加载（%d 条），合并后共 %d 个网段", sourceNames[source], s.Name, len(items), count)
+}
+
+// LoadAPI 解析 API 返回的 JSON 全量数据，作为 Fetcher 的 OnData 回调
+func (s *Sources[T]) LoadAPI(body []byte) error {
+	var items []T
+	if err := json.Unmarshal(body, &items); err != nil {
+		return err
+	}
+	s.Set(SourceAPI, items)
+	return nil
+}
+
+// LoadDelta 在当前 API 数据上应用增量变更，返回合并后的全量数据，作为 Fetcher 的 OnDelta 回调
+func (s *Sources[T]) LoadDelta(body []byte) ([]byte, error) {
+	var delta Delta[T]
+	if err := json.Unmarshal(body, &delta); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	items := ApplyDelta(s.layers[SourceAPI], s.Key, delta)
+	s.mu.Unlock()
+	s.Set(SourceAPI, items)
+	return json.Marshal(items)
+}
+
+// LoadFile 按 Format 解析本地文件，作为 FileWatcher 的 OnData 回调
+func (s *Sources[T]) LoadFile(body []byte) error {
+	items, err := ParseList(s.Format, body, s.FromRecord)
+	if err != nil {
+		return err
+	}
+	s.Set(SourceFile, items)
+	return nil
+}
+
+// Merged 合并后的全部条目
+func (s *Sources[T]) Merged() []T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.merged
+}
+
+// Counts 各来源的条目数，键为来源名称
+func (s *Sources[T]) Counts() map[string]int {
+	counts := make(map[string]int, sourceCount)
+	s.mu.Lock()
+	for i, items := range s.layers {
+		counts[sourceNames[i]] = len(items)
+	}
+	s.mu.Unlock()
+	return counts
+}
+
+// Merge 合并多个来源的映射，后面的来源优先级更高：key 相同时覆盖前面来源的条目，顺序按首次出现
+func Merge[T any](key func(T) string, layers ...[]T) []T {
+	index := make(map[string]int)
+	var merged []T
+	for _, layer := range layers {
+		for _, item := range layer {
+			k := key(item)
+			if i, ok := index[k]; ok {
+				merged[i] = item
+				continue
+			}
+			index[k] = len(merged)
+			merged = append(merged, item)
+		}
+	}
+	return merged
+}
+
+// Delta 增量接口返回的变更：Added 为新增或更新的条目，Removed 为删除的条目（仅需包含主键字段）
+type Delta[T any] struct {
+	Version string `json:"version,omitempty"`
+	Added   []T    `json:"added,omitempty"`
+	Removed []T    `json:"removed,omitempty"`
+}
+
+// ApplyDelta 在 base 上应用增量变更，返回新的列表，不修改 base
+func ApplyDelta[T any](base []T, key func(T) string, delta Delta[T]) []T {
+	removed := make(map[string]bool, len(delta.Removed))
+	for _, item := range delta.Removed {
+		removed[key(item)] = true
+	}
+	kept := make([]T, 0, len(base)+len(delta.Added))
+	for _, item := range base {
+		if !removed[key(item)] {
+			kept = append(kept, item)
+		}
+	}
+	return Merge(key, kept, delta.Added)
+}
+
+// FileWatcher 定期检查本地文件的修改时间与大小，发生变化时重新加载
+type FileWatcher struct {
+	Log      *Logger                 // 插件日志
+	Path     string                  // 文件路径
+	Interval time.Duration           // 检查间隔
+	OnData   func(body []byte) error // 数据处理回调，返回错误表示数据无效
+	// OnChange 文件变化时回调，设置后不再读取文件内容调用 OnData，由调用方自行打开文件（如 mmap 方式打开大文件）
+	OnChange func() error
+
+	modTime  time.Time
+	size     int64
+	missing  bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Start 同步加载一次，然后在后台按间隔检查文件变化
+func (f *FileWatcher) Start() {
+	f.stop = make(chan struct{})
+	f.Check()
+	interval := f.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-f.stop:
+				return
+			case <-ticker.C:
+				f.Check()
+			}
+		}
+	}()
+}
+
+// Stop 停止后台检查
+func (f *FileWatcher) Stop() error {
+	f.stopOnce.Do(func() {
+		if f.stop != nil {
+			close(f.stop)
+		}
+	})
+	return nil
+}
+
+// Check 文件有变化时重新加载；加载失败时保留原数据，文件再次变化后重试
+func (f *FileWatcher) Check() error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		// 文件缺失时只在首次提示，避免每个周期重复告警
+		if !f.missing {
+			f.Log.Warningf("stat file %s error: %v", f.Path, err)
+		}
+		f.missing = true
+		return err
+	}
+	f.missing = false
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	if f.OnChange != nil {
+		if err := f.OnChange(); err != nil {
+			f.Log.Warningf("load file %s error: %v", f.Path, err)
+			return err
+		}
+		return nil
+	}
+	body, err := os.ReadFile(f.Path)
+	if err != nil {
+		f.Log.Warningf("read file %s error: %v", f.Path, err)
+		return err
+	}
+	if err := f.OnData(body); err != nil {
+		f.Log.Warningf("load file %s error: %v", f.Path, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSourcesMerge(t *testing.T) {
+	tests := []struct {
+		name       string
+		api        []testItem
+		file       []testItem
+		inline     []testItem
+		want       []testItem
+		wantCounts map[string]int
+	}{
+		{
+			name: "api only", api: []testItem{{"a", "api"}},
+			want: []testItem{{"a", "api"}}, wantCounts: map[string]int{"API": 1, "file": 0, "inline": 0},
+		},
+		{
+			name: "file overrides api", api: []testItem{{"a", "api"}, {"b", "api"}}, file: []testItem{{"b", "file"}},
+			want: []testItem{{"a", "api"}, {"b", "file"}}, wantCounts: map[string]int{"API": 2, "file": 1, "inline": 0},
+		},
+		{
+			name: "inline overrides all", api: []testItem{{"a", "api"}}, file: []testItem{{"a", "file"}, {"c", "file"}}, inline: []testItem{{"a", "inline"}},
+			want: []testItem{{"a", "inline"}, {"c", "file"}}, wantCounts: map[string]int{"API": 1, "file": 2, "inline": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSources()
+			var built []testItem
+			s.Build = func(merged []testItem) int { built = merged; return len(merged) }
+			// 按与启动时相反的顺序设置，结果只取决于优先级
+			s.Set(SourceInline, tt.inline)
+			s.Set(SourceFile, tt.file)
+			s.Set(SourceAPI, tt.api)
+			if got := s.Merged(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merged() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(built, tt.want) {
+				t.Errorf("Build got %v, want %v", built, tt.want)
+			}
+			if got := s.Counts(); !reflect.DeepEqual(got, tt.wantCounts) {
+				t.Errorf("Counts() = %v, want %v", got, tt.wantCounts)
+			}
+		})
+	}
+}
+
+func TestSourcesLoadFile(t *testing.T) {
+	fromRecord := func(record map[string]string) (testItem, error) {
+		return testItem{K: record["k"], V: record["v"]}, nil
+	}
+	tests := []struct {
+		name    string
+		format  string
+		body    string
+		want    []testItem
+		wantErr bool
+	}{
+		{name: "json", format: FormatJSON, body: `[{"k":"a","v":"1"}]`, want: []testItem{{"a", "1"}}},
+		{name: "yaml", format: FormatYAML, body: "- k: a\n  v: \"1\"\n", want: []testItem{{"a", "1"}}},
+		{name: "csv", format: FormatCSV, body: "k,v\na,1\nb,2\n", want: []testItem{{"a", "1"}, {"b", "2"}}},
+		{name: "invalid json keeps data", format: FormatJSON, body: `[{`, want: []testItem{{"old", "1"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSources()
+			s.Format, s.FromRecord = tt.format, fromRecord
+			s.Set(SourceFile, []testItem{{"old", "1"}})
+			err := s.LoadFile([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := s.Merged(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Merged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileWatcherCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	var loads []string
+	f := &FileWatcher{Log: NewLogger("test"), Path: path, OnData: func(body []byte) error {
+		loads = append(loads, string(body))
+		return nil
+	}}
+	steps := []struct {
+		name      string
+		write     string // 写入的内容，为空表示不修改文件
+		wantErr   bool
+		wantLoads []string
+	}{
+		{name: "missing file", wantErr: true},
+		{name: "created", write: "v1", wantLoads: []string{"v1"}},
+		{name: "unchanged", wantLoads: []string{"v1"}},
+		{name: "modified", write: "v22", wantLoads: []string{"v1", "v22"}},
+	}
+	mtime := time.Now().Add(-time.Hour)
+	for _, step := range steps {
+		if step.write != "" {
+			if err := os.WriteFile(path, []byte(step.write), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			// 每次写入使用不同的修改时间，不依赖文件系统的时间精度
+			mtime = mtime.Add(time.Second)
+			if err := os.Chtimes(path, mtime, mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := f.Check()
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: Check() error = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		if !reflect.DeepEqual(loads, step.wantLoads) {
+			t.Errorf("%s: loads = %q, want %q", step.name, loads, step.wantLoads)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package common
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yl2chen/cidranger"
+)
+
+// DefaultCacheSize 查找缓存默认容量
+const DefaultCacheSize = 1024
+
+// minCacheFlush 未命中结果攒够该数量（或当前快照的 1/4）后才合并进缓存快照，避免每次未命中都复制快照
+const minCacheFlush = 64
+
+// Entry 网段及其关联的值
+type Entry[V any] struct {
+	Prefix netip.Prefix
+	Value  V
+}
+
+// CIDRTable 可热加载的 网段→值 查找表，基于 Trie 进行最长前缀匹配，并缓存热点地址的查找结果。
+// 每次热加载在旁路构建新的 Trie，通过原子指针发布为新版本，查询路径不加锁；
+// 缓存属于表版本，是原子发布的只读 map，命中时不加锁、不分配内存；未命中的结果攒批后复制合并为新快照，
+// 超过容量时整体换代。热加载发布的新版本从空缓存开始，无需清空旧缓存
+type CIDRTable[V any] struct {
+	// OnLookup 每次查找时回调，hit 表示是否命中缓存，用于统计缓存命中率
+	OnLookup func(hit bool)
+
+	current  atomic.Pointer[tableVersion[V]]
+	versions atomic.Uint64
+	capacity int
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+}
+
+// tableVersion 一个不可变的查找表版本及其查找结果缓存
+type tableVersion[V any] struct {
+	ranger  cidranger.Ranger
+	count   int
+	version uint64
+
+	cache   atomic.Pointer[map[netip.Addr]lookupResult[V]] // 只读缓存快照
+	mu      sync.Mutex                                     // 保护 pending 与快照合并
+	pending map[netip.Addr]lookupResult[V]                 // 尚未合并进快照的未命中结果
+}
+
+// lookupResult 缓存的查找结果（包括未命中）
+type lookupResult[V any] struct {
+	entry Entry[V]
+	ok    bool
+}
+
+// rangerEntry 实现 cidranger.RangerEntry 接口
+type rangerEntry[V any] struct {
+	network net.IPNet
+	entry   Entry[V]
+}
+
+func (e *rangerEntry[V]) Network() net.IPNet {
+	return e.network
+}
+
+// NewCIDRTable 创建查找表，cacheSize <= 0 时使用默认容量
+func NewCIDRTable[V any](cacheSize int) *CIDRTable[V] {
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
+	return &CIDRTable[V]{capacity: cacheSize}
+}
+
+// Replace 用新数据构建新版本的 Trie 并原子替换，返回实际加载的网段数。
+// 构建过程不影响正在进行的查询，旧版本的缓存随旧版本一起释放；多个来源更新时需由调用方串行调用
+func (t *CIDRTable[V]) Replace(entries []Entry[V]) int {
+	ranger := cidranger.NewPCTrieRanger()
+	count := 0
+	for _, entry := range entries {
+		if !entry.Prefix.IsValid() {
+			continue
+		}
+		entry.Prefix = entry.Prefix.Masked()
+		network := net.IPNet{
+			IP:   entry.Prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(entry.Prefix.Bits(), entry.Prefix.Addr().BitLen()),
+		}
+		if err := ranger.Insert(&rangerEntry[V]{network: network, entry: entry}); err == nil {
+			count++
+		}
+	}
+	t.current.Store(&tableVersion[V]{ranger: ranger, count: count, version: t.versions.Add(1)})
+	return count
+}
+
+// Lookup 查找包含该地址的最精确网段
+func (t *CIDRTable[V]) Lookup(addr netip.Addr) (Entry[V], bool) {
+	tv := t.current.Load()
+	if tv == nil || !addr.IsValid() {
+		return Entry[V]{}, false
+	}
+	if cache := tv.cache.Load(); cache != nil {
+		if res, ok := (*cache)[addr]; ok {
+			t.hits.Add(1)
+			if t.OnLookup != nil {
+				t.OnLookup(true)
+			}
+			return res.entry, res.ok
+		}
+	}
+	t.misses.Add(1)
+	if t.OnLookup != nil {
+		t.OnLookup(false)
+	}
+
+	var res lookupResult[V]
+	entries, err := tv.ranger.ContainingNetworks(addr.AsSlice())
+	if err == nil && len(entries) > 0 {
+		// ContainingNetworks 按掩码从短到长返回，最后一个即最精确匹配
+		if e, ok := entries[len(entries)-1].(*rangerEntry[V]); ok {
+			res.entry, res.ok = e.entry, true
+		}
+	}
+	tv.remember(addr, res, t.capacity)
+	return res.entry, res.ok
+}
+
+// remember 记录未命中的查找结果：攒够一批后复制当前快照并合并发布；合并后超过容量时丢弃旧快照，
+// 只保留本批结果（按代淘汰，仍在访问的热点地址很快重新进入缓存）
+func (tv *tableVersion[V]) remember(addr netip.Addr, res lookupResult[V], capacity int) {
+	tv.mu.Lock()
+	defer tv.mu.Unlock()
+	if tv.pending == nil {
+		tv.pending = make(map[netip.Addr]lookupResult[V], minCacheFlush)
+	}
+	tv.pending[addr] = res
+	var old map[netip.Addr]lookupResult[V]
+	if cache := tv.cache.Load(); cache != nil {
+		old = *cache
+	}
+	if n := len(tv.pending); n < capacity && n < max(minCacheFlush, len(old)/4) {
+		return
+	}
+	if len(old)+len(tv.pending) > capacity {
+		old = nil
+	}
+	next := make(map[netip.Addr]lookupResult[V], len(old)+len(tv.pending))
+	for k, v := range old {
+		next[k] = v
+	}
+	for k, v := range tv.pending {
+		next[k] = v
+	}
+	tv.cache.Store(&next)
+	tv.pending = nil
+}
+
+// Loaded 是否已成功加载过数据
+func (t *CIDRTable[V]) Loaded() bool {
+	return t.current.Load() != nil
+}
+
+// Len 当前加载的网段数
+func (t *CIDRTable[V]) Len() int {
+	if tv := t.current.Load(); tv != nil {
+		return tv.count
+	}
+	return 0
+}
+
+// Version 当前查找表版本，每次热加载递增，未加载时为 0
+func (t *CIDRTable[V]) Version() uint64 {
+	if tv := t.current.Load(); tv != nil {
+		return tv.version
+	}
+	return 0
+}
+
+// Stats 缓存统计，Size 为当前版本缓存快照中的条目数（不含尚未合并的未命中结果）
+func (t *CIDRTable[V]) Stats() CacheStats {
+	stats := CacheStats{Capacity: t.capacity, Hits: t.hits.Load(), Misses: t.misses.Load()}
+	if tv := t.current.Load(); tv != nil {
+		if cache := tv.cache.Load(); cache != nil {
+			stats.Size = len(*cache)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,219 @@
+package common
+
+import (
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/yl2chen/cidranger"
+)
+
+// 基准数据规模：5000 个 /24 网段（Replace 一次约数十毫秒，计时期间能完成足够多次热加载），4096 条缓存
+const (
+	benchPrefixes  = 5000
+	benchCacheSize = 4096
+)
+
+// benchEntries 10.0.0.0/24 起连续的 /24 网段
+func benchEntries() []Entry[string] {
+	entries := make([]Entry[string], benchPrefixes)
+	for i := range entries {
+		addr := netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 0})
+		entries[i] = Entry[string]{Prefix: netip.PrefixFrom(addr, 24), Value: "az"}
+	}
+	return entries
+}
+
+// benchAddrs n 个落在网段内的随机地址
+func benchAddrs(n int) []netip.Addr {
+	rnd := rand.New(rand.NewSource(1))
+	addrs := make([]netip.Addr, n)
+	for i := range addrs {
+		j := rnd.Intn(benchPrefixes)
+		addrs[i] = netip.AddrFrom4([4]byte{10, byte(j >> 8), byte(j), byte(rnd.Intn(256))})
+	}
+	return addrs
+}
+
+// lockedTable 对照实现：读写锁保护的 Trie + 单个 LRU，热加载时清空缓存
+type lockedTable struct {
+	mu     sync.RWMutex
+	ranger cidranger.Ranger
+	cache  *lru.Cache
+}
+
+func newLockedTable(size int) *lockedTable {
+	cache, _ := lru.New(size)
+	return &lockedTable{cache: cache}
+}
+
+func (t *lockedTable) Replace(entries []Entry[string]) {
+	ranger := cidranger.NewPCTrieRanger()
+	for _, entry := range entries {
+		network := net.IPNet{
+			IP:   entry.Prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(entry.Prefix.Bits(), entry.Prefix.Addr().BitLen()),
+		}
+		ranger.Insert(&rangerEntry[string]{network: network, entry: entry})
+	}
+	t.mu.Lock()
+	t.ranger = ranger
+	t.cache.Purge()
+	t.mu.Unlock()
+}
+
+func (t *lockedTable) Lookup(addr netip.Addr) (Entry[string], bool) {
+	if v, ok := t.cache.Get(addr); ok {
+		res := v.(lookupResult[string])
+		return res.entry, res.ok
+	}
+	t.mu.RLock()
+	entries, err := t.ranger.ContainingNetworks(addr.AsSlice())
+	t.mu.RUnlock()
+	var res lookupResult[string]
+	if err == nil && len(entries) > 0 {
+		if e, ok := entries[len(entries)-1].(*rangerEntry[string]); ok {
+			res.entry, res.ok = e.entry, true
+		}
+	}
+	t.cache.Add(addr, res)
+	return res.entry, res.ok
+}
+
+// lookupTable 基准对比的两种实现
+type lookupTable interface {
+	Replace(entries []Entry[string])
+	Lookup(addr netip.Addr) (Entry[string], bool)
+}
+
+// cidrTable 适配 CIDRTable.Replace 的返回值
+type cidrTable struct{ *CIDRTable[string] }
+
+func (t cidrTable) Replace(entries []Entry[string]) { t.CIDRTable.Replace(entries) }
+
+// BenchmarkCIDRTableLookup 并发查询的同时后台不间断地热加载（上一次 Replace 完成即开始下一次），
+// 对比原子替换与读写锁两种实现；多核机器上用 -cpu 1,4,8 观察并发度的影响
+func BenchmarkCIDRTableLookup(b *testing.B) {
+	entries := benchEntries()
+	tables := []struct {
+		name string
+		new  func() lookupTable
+	}{
+		{"atomic", func() lookupTable { return cidrTable{NewCIDRTable[string](benchCacheSize)} }},
+		{"rwmutex", func() lookupTable { return newLockedTable(benchCacheSize) }},
+	}
+	workloads := []struct {
+		name  string
+		addrs []netip.Addr
+	}{
+		{"hot", benchAddrs(1000)},
+		{"random", benchAddrs(100000)},
+	}
+	for _, w := range workloads {
+		for _, tc := range tables {
+			b.Run(w.name+"/"+tc.name, func(b *testing.B) {
+				table := tc.new()
+				table.Replace(entries)
+				stop := make(chan struct{})
+				var reloads atomic.Int64
+				go func() {
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						table.Replace(entries)
+						reloads.Add(1)
+					}
+				}()
+				var seed atomic.Int64
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := int(seed.Add(7919))
+					for pb.Next() {
+						if _, ok := table.Lookup(w.addrs[i%len(w.addrs)]); !ok {
+							b.Error("lookup missed")
+							return
+						}
+						i++
+					}
+				})
+				b.StopTimer()
+				close(stop)
+				b.ReportMetric(float64(reloads.Load()), "reloads")
+			})
+		}
+	}
+}
+
+func TestCIDRTableReplace(t *testing.T) {
+	table := NewCIDRTable[string](64)
+	addr := netip.MustParseAddr("10.1.2.3")
+	if _, ok := table.Lookup(addr); ok {
+		t.Fatal("lookup before load should miss")
+	}
+	table.Replace([]Entry[string]{
+		{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: "wide"},
+		{Prefix: netip.MustParsePrefix("10.1.2.0/24"), Value: "narrow"},
+	})
+	if e, ok := table.Lookup(addr); !ok || e.Value != "narrow" {
+		t.Fatalf("Lookup() = %v, %v, want narrow", e, ok)
+	}
+	// 热加载后缓存中的旧版本结果不再返回
+	table.Replace([]Entry[string]{{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: "wide"}})
+	if e, ok := table.Lookup(addr); !ok || e.Value != "wide" {
+		t.Fatalf("Lookup() after Replace = %v, %v, want wide", e, ok)
+	}
+}
+
+func TestCIDRTableCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		capacity   int
+		addrs      int // 依次查询的不同地址数
+		rounds     int // 查询轮数
+		wantSize   int
+		wantHits   uint64
+		wantMisses uint64
+	}{
+		// 第一轮攒够 64 条未命中后合并，第二轮全部命中
+		{name: "hot set cached", capacity: 1024, addrs: 64, rounds: 2, wantSize: 64, wantHits: 64, wantMisses: 64},
+		// 未攒够一批时不合并，重复查询仍未命中
+		{name: "pending not cached", capacity: 1024, addrs: 10, rounds: 2, wantSize: 0, wantHits: 0, wantMisses: 20},
+		// 容量小于一批时按容量合并
+		{name: "small capacity", capacity: 8, addrs: 8, rounds: 2, wantSize: 8, wantHits: 8, wantMisses: 8},
+		// 超过容量时换代，只保留最近一批
+		{name: "generation reset", capacity: 100, addrs: 192, rounds: 1, wantSize: 64, wantMisses: 192},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := NewCIDRTable[string](tt.capacity)
+			table.Replace([]Entry[string]{{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: "az1"}})
+			for r := 0; r < tt.rounds; r++ {
+				for i := 0; i < tt.addrs; i++ {
+					addr := netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)})
+					if e, ok := table.Lookup(addr); !ok || e.Value != "az1" {
+						t.Fatalf("Lookup(%s) = %v, %v, want az1", addr, e, ok)
+					}
+				}
+			}
+			stats := table.Stats()
+			if stats.Size != tt.wantSize || stats.Hits != tt.wantHits || stats.Misses != tt.wantMisses {
+				t.Errorf("Stats() = %+v, want size %d hits %d misses %d", stats, tt.wantSize, tt.wantHits, tt.wantMisses)
+			}
+			if stats.Size > tt.capacity {
+				t.Errorf("cache size %d exceeds capacity %d", stats.Size, tt.capacity)
+			}
+			// 热加载后从空缓存开始
+			table.Replace([]Entry[string]{{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Value: "az2"}})
+			if stats := table.Stats(); stats.Size != 0 {
+				t.Errorf("Stats().Size after Replace = %d, want 0", stats.Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"context"
+	"sync"
+)
+
+// traceKey 模拟解析时在 context 中携带 Trace 的键
+type traceKey struct{}
+
+// TraceHop 插件链上一个路由插件的决策
+type TraceHop struct {
+	Plugin   string   `json:"plugin"`
+	Decision Decision `json:"decision"`
+}
+
+// Trace 收集一次模拟解析中各插件的决策
+type Trace struct {
+	mu   sync.Mutex
+	hops []TraceHop
+}
+
+// WithTrace 返回携带 Trace 的 context，插件通过 TraceFrom 取出并记录决策
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{}
+	return context.WithValue(ctx, traceKey{}, t), t
+}
+
+// TraceFrom 取出 context 中的 Trace，普通查询返回 nil
+func TraceFrom(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Add 记录插件决策，t 为 nil 时忽略
+func (t *Trace) Add(plugin string, d Decision) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.hops = append(t.hops, TraceHop{Plugin: plugin, Decision: d})
+	t.mu.Unlock()
+}
+
+// Hops 已记录的决策。插件在下游返回后才做决策，因此顺序为插件链的逆序（最内层在前）
+func (t *Trace) Hops() []TraceHop {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceHop(nil), t.hops...)
+}
@@ -0,0 +1,104 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+// TTLDegraded ttl 指令中表示降级状态的关键字
+const TTLDegraded = "degraded"
+
+// TTLPolicy 按决策层级限制返回记录的 TTL：只调低不调高，下游 TTL 更短时保持不变
+type TTLPolicy struct {
+	Tiers    map[string]uint32 // 决策层级 -> TTL 上限（秒）
+	Degraded uint32            // 降级状态（如 API 拉取失败）下的 TTL 上限，0 表示不限制
+}
+
+// ParseDirective 解析 ttl 指令，返回 false 表示不是 ttl 指令：
+//
+//	ttl TIER... SECONDS
+//	ttl degraded SECONDS
+func (p *TTLPolicy) ParseDirective(c *caddy.Controller) (bool, error) {
+	if c.Val() != "ttl" {
+		return false, nil
+	}
+	args := c.RemainingArgs()
+	if len(args) < 2 {
+		return true, c.ArgErr()
+	}
+	var ttl uint32
+	if _, err := fmt.Sscanf(args[len(args)-1], "%d", &ttl); err != nil || ttl == 0 {
+		return true, c.Errf("invalid ttl value: %s", args[len(args)-1])
+	}
+	for _, tier := range args[:len(args)-1] {
+		if tier == TTLDegraded {
+			p.Degraded = ttl
+			continue
+		}
+		if p.Tiers == nil {
+			p.Tiers = make(map[string]uint32)
+		}
+		p.Tiers[tier] = ttl
+	}
+	return true, nil
+}
+
+// Validate 检查配置的决策层级是否为插件支持的层级
+func (p *TTLPolicy) Validate(tiers ...string) error {
+	for tier := range p.Tiers {
+		found := false
+		for _, t := range tiers {
+			found = found || t == tier
+		}
+		if !found {
+			return fmt.Errorf("invalid ttl tier: %s (expected one of %s, %s)", tier, strings.Join(tiers, ", "), TTLDegraded)
+		}
+	}
+	return nil
+}
+
+// Cap 决策层级对应的 TTL 上限，降级状态下取两者中较小的值；0 表示不限制
+func (p *TTLPolicy) Cap(tier string, degraded bool) uint32 {
+	limit := p.Tiers[tier]
+	if degraded && p.Degraded > 0 && (limit == 0 || p.Degraded < limit) {
+		limit = p.Degraded
+	}
+	return limit
+}
+
+// CapTTL 将响应中最终 A/AAAA 记录集及其签名的 TTL 调低到 limit；签名的原始 TTL 不变，验证不受影响
+func CapTTL(m *dns.Msg, addrs []dns.RR, limit uint32) {
+	if limit == 0 || len(addrs) == 0 {
+		return
+	}
+	name, qtype := addrs[0].Header().Name, addrs[0].Header().Rrtype
+	for _, rr := range m.Answer {
+		hdr := rr.Header()
+		if !strings.EqualFold(hdr.Name, name) || hdr.Ttl <= limit {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); hdr.Rrtype == qtype || (ok && sig.TypeCovered == qtype) {
+			hdr.Ttl = limit
+		}
+	}
+}
+
+// SetTTL 在决策中记录 TTL 上限与降级状态
+func (d *Decision) SetTTL(ttl uint32, degraded bool) {
+	if ttl == 0 && !degraded {
+		return
+	}
+	if d.Attrs == nil {
+		d.Attrs = make(map[string]string)
+	}
+	if ttl > 0 {
+		d.Attrs["ttl"] = strconv.FormatUint(uint64(ttl), 10)
+	}
+	if degraded {
+		d.Attrs["degraded"] = "true"
+	}
+}
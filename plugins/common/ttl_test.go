@@ -0,0 +1,162 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"github.com/miekg/dns"
+)
+
+func TestTTLPolicyParseDirective(t *testing.T) {
+	tests := []struct {
+		input    string
+		want     TTLPolicy
+		wantNone bool // 不是 ttl 指令
+		wantErr  bool
+	}{
+		{input: "ttl matched 30", want: TTLPolicy{Tiers: map[string]uint32{"matched": 30}}},
+		{input: "ttl matched fallback 60", want: TTLPolicy{Tiers: map[string]uint32{"matched": 60, "fallback": 60}}},
+		{input: "ttl degraded 10", want: TTLPolicy{Degraded: 10}},
+		{input: "ttl matched", wantErr: true},
+		{input: "ttl matched 0", wantErr: true},
+		{input: "ttl matched x", wantErr: true},
+		{input: "max_answers 2", wantNone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			c := caddy.NewTestController("dns", tt.input)
+			c.Next()
+			var p TTLPolicy
+			ok, err := p.ParseDirective(c)
+			if ok == tt.wantNone {
+				t.Fatalf("ParseDirective() = %v, want %v", ok, !tt.wantNone)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDirective() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(p, tt.want) {
+				t.Errorf("policy = %+v, want %+v", p, tt.want)
+			}
+		})
+	}
+}
+
+func TestTTLPolicyValidate(t *testing.T) {
+	p := TTLPolicy{Tiers: map[string]uint32{"matched": 30}}
+	if err := p.Validate("matched", "fallback"); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := p.Validate("internal", "external"); err == nil {
+		t.Error("Validate() accepted unknown tier")
+	}
+}
+
+func TestTTLPolicyCap(t *testing.T) {
+	p := TTLPolicy{Tiers: map[string]uint32{"matched": 60, "fallback": 5}, Degraded: 10}
+	tests := []struct {
+		tier     string
+		degraded bool
+		want     uint32
+	}{
+		{tier: "matched", want: 60},
+		{tier: "fallback", want: 5},
+		{tier: "other", want: 0},
+		{tier: "matched", degraded: true, want: 10},
+		{tier: "fallback", degraded: true, want: 5}, // 层级上限更小时保持不变
+		{tier: "other", degraded: true, want: 10},
+	}
+	for _, tt := range tests {
+		if got := p.Cap(tt.tier, tt.degraded); got != tt.want {
+			t.Errorf("Cap(%s, %v) = %d, want %d", tt.tier, tt.degraded, got, tt.want)
+		}
+	}
+	if got := (&TTLPolicy{Tiers: map[string]uint32{"matched": 60}}).Cap("matched", true); got != 60 {
+		t.Errorf("Cap without degraded limit = %d, want 60", got)
+	}
+}
+
+func TestCapTTL(t *testing.T) {
+	const (
+		cname = "www.example.org.\t300\tIN\tCNAME\tedge.example.net."
+		edgeA = "edge.example.net.\t300\tIN\tA\t192.0.2.1"
+		short = "edge.example.net.\t20\tIN\tA\t192.0.2.2"
+	)
+	tests := []struct {
+		name  string
+		limit uint32
+		want  []string
+	}{
+		{name: "no limit", limit: 0, want: []string{cname, edgeA, short}},
+		{
+			name: "lower final rrset only", limit: 30,
+			want: []string{cname, "edge.example.net.\t30\tIN\tA\t192.0.2.1", short},
+		},
+		{
+			name: "never raise", limit: 600,
+			want: []string{cname, edgeA, short},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := answerMsg(t, cname, edgeA, short)
+			addrs, _ := AddrRRs(m)
+			CapTTL(m, addrs, tt.limit)
+			if got := rrStrings(m.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapTTLSignature(t *testing.T) {
+	k := newTestKey(t)
+	m, rrs := signedResponse(t, k, "192.0.2.1", "192.0.2.2")
+	sig := m.Answer[2].(*dns.RRSIG)
+	sig.Hdr.Ttl = 300
+	other := &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: "www.example.org.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 300},
+		TypeCovered: dns.TypeTXT,
+		OrigTtl:     300,
+	}
+	m.Answer = append(m.Answer, other)
+	CapTTL(m, rrs, 30)
+	for _, rr := range m.Answer[:3] {
+		if rr.Header().Ttl != 30 {
+			t.Errorf("%s: ttl = %d, want 30", rr, rr.Header().Ttl)
+		}
+	}
+	if sig.OrigTtl != 300 {
+		t.Errorf("RRSIG original ttl = %d, want 300", sig.OrigTtl)
+	}
+	if err := sig.Verify(k.Key, rrs); err != nil {
+		t.Errorf("signature invalid after capping: %v", err)
+	}
+	if !sig.ValidityPeriod(time.Now()) {
+		t.Error("signature validity period changed")
+	}
+	if other.Hdr.Ttl != 300 {
+		t.Errorf("RRSIG covering TXT capped to %d", other.Hdr.Ttl)
+	}
+}
+
+func TestDecisionSetTTL(t *testing.T) {
+	tests := []struct {
+		ttl      uint32
+		degraded bool
+		want     map[string]string
+	}{
+		{ttl: 0, want: nil},
+		{ttl: 30, want: map[string]string{"ttl": "30"}},
+		{ttl: 10, degraded: true, want: map[string]string{"ttl": "10", "degraded": "true"}},
+		{ttl: 0, degraded: true, want: map[string]string{"degraded": "true"}},
+	}
+	for _, tt := range tests {
+		var d Decision
+		d.SetTTL(tt.ttl, tt.degraded)
+		if !reflect.DeepEqual(d.Attrs, tt.want) {
+			t.Errorf("SetTTL(%d, %v) attrs = %v, want %v", tt.ttl, tt.degraded, d.Attrs, tt.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// errNoVersion 尚未从全量接口获得数据版本，无法订阅增量变更
+var errNoVersion = errors.New("data version unknown")
+
+// watch 长轮询增量接口：请求在服务端挂起，直到数据版本变化（立即应用变更）或超时（重新发起）。
+// 请求失败时按退避间隔重连，期间由定期拉取兜底
+func (f *Fetcher) watch() {
+	client := &http.Client{Timeout: f.LongPoll + f.timeout()}
+	failures, dropped := 0, false
+	for {
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+		err := f.longPoll(client)
+		if err == nil || errors.Is(err, ErrNotModified) {
+			if dropped {
+				f.Log.Infof("long poll on %s recovered", f.DeltaURL)
+			}
+			failures, dropped = 0, false
+			continue
+		}
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+		failures++
+		if !dropped && !errors.Is(err, errNoVersion) {
+			dropped = true
+			f.Log.Warningf("long poll on %s dropped, falling back to polling: %v", f.DeltaURL, err)
+		}
+		select {
+		case <-f.stop:
+			return
+		case <-time.After(f.backoff(failures)):
+		}
+	}
+}
+
+// longPoll 发起一次长轮询。请求期间不持有锁，返回后若版本已被定期拉取更新则丢弃本次结果
+func (f *Fetcher) longPoll(client *http.Client) error {
+	f.mu.Lock()
+	since := f.version
+	f.mu.Unlock()
+	if since == "" {
+		return errNoVersion
+	}
+	u, err := f.deltaURL(since, f.LongPoll)
+	if err != nil {
+		return err
+	}
+	status, header, body, err := f.get(client, u, nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotModified {
+		return ErrNotModified
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.version != since {
+		return nil
+	}
+	err = f.applyDelta(header, body)
+	f.record(err)
+	return err
+}
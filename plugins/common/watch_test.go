@@ -0,0 +1,72 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFetcherLongPoll(t *testing.T) {
+	const body = `{"added":[{"k":"b","v":"1"}]}`
+	tests := []struct {
+		name        string
+		version     string // 长轮询开始时的数据版本
+		status      int
+		concurrent  bool // 请求期间定期拉取更新了版本
+		wantErr     error
+		wantVersion string
+		want        []testItem
+	}{
+		{name: "no version", wantErr: errNoVersion, want: []testItem{{"a", "1"}}},
+		{name: "not modified", version: "1", status: http.StatusNotModified, wantErr: ErrNotModified, wantVersion: "1", want: []testItem{{"a", "1"}}},
+		{name: "delta applied", version: "1", status: http.StatusOK, wantVersion: "2", want: []testItem{{"a", "1"}, {"b", "1"}}},
+		{name: "stale result dropped", version: "1", status: http.StatusOK, concurrent: true, wantVersion: "5", want: []testItem{{"a", "1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources := newTestSources()
+			sources.Set(SourceAPI, []testItem{{"a", "1"}})
+			var f *Fetcher
+			var query map[string][]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				query = r.URL.Query()
+				if tt.concurrent {
+					f.mu.Lock()
+					f.version = "5"
+					f.mu.Unlock()
+				}
+				w.Header().Set(VersionHeader, "2")
+				w.WriteHeader(tt.status)
+				w.Write([]byte(body))
+			}))
+			defer server.Close()
+			f = &Fetcher{
+				FetchConfig: FetchConfig{DeltaURL: server.URL, LongPoll: 30 * time.Second},
+				Log:         NewLogger("test"),
+				OnDelta:     sources.LoadDelta,
+				version:     tt.version,
+			}
+			err := f.longPoll(server.Client())
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("longPoll() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.version != "" {
+				if got := query["since"]; !reflect.DeepEqual(got, []string{tt.version}) {
+					t.Errorf("since = %v, want %s", got, tt.version)
+				}
+				if got := query["wait"]; !reflect.DeepEqual(got, []string{"30s"}) {
+					t.Errorf("wait = %v, want 30s", got)
+				}
+			}
+			if f.version != tt.wantVersion {
+				t.Errorf("version = %q, want %q", f.version, tt.wantVersion)
+			}
+			if got := sources.Merged(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package georoute
+
+import (
+	"net/netip"
+	"time"
+
+	"coredns-plugins/plugins/common"
+)
+
+// geoStatus 管理接口展示的加载状态
+type geoStatus struct {
+	GeoIPDB           string        `json:"geoip_db"`
+	Loaded            bool          `json:"loaded"`
+	BuildEpoch        time.Time     `json:"build_epoch,omitempty"`
+	DistanceThreshold float64       `json:"distance_threshold"`
+	Rank              string        `json:"rank"`
+	Servers           *serverStatus `json:"servers,omitempty"`
+	ASNDB             string        `json:"asn_db,omitempty"`
+	ASNLoaded         bool          `json:"asn_loaded,omitempty"`
+	PolicyRules       int           `json:"policy_rules"`
+	PolicyFile        string        `json:"policy_file,omitempty"`
+}
+
+// serverStatus 管理接口展示的服务器位置表加载状态
+type serverStatus struct {
+	Entries int                 `json:"entries"`
+	Sources map[string]int      `json:"sources"`
+	Fetch   *common.FetchStatus `json:"fetch,omitempty"`
+	File    string              `json:"file,omitempty"`
+}
+
+// geoLookup 管理接口的地址查询结果
+type geoLookup struct {
+	Internal bool         `json:"internal"`
+	Location *GeoLocation `json:"location,omitempty"`
+	Server   *GeoLocation `json:"server,omitempty"` // 作为服务器 IP 时使用的位置（服务器位置表优先）
+	ASN      uint         `json:"asn,omitempty"`
+	Policy   string       `json:"policy,omitempty"` // 作为客户端时第一条满足条件的策略规则
+}
+
+// Status GeoIP 数据库与距离阈值
+func (s *GeoRoute) Status() interface{} {
+	status := geoStatus{
+		GeoIPDB:           s.GeoIPDBPath,
+		DistanceThreshold: s.DistanceThreshold,
+		Rank:              s.Rank,
+		ASNDB:             s.ASNDBPath,
+		ASNLoaded:         s.ASNDB != nil && s.ASNDB.Loaded(),
+		PolicyRules:       s.Policy.Rules(),
+		PolicyFile:        s.Policy.FilePath,
+	}
+	if s.DB != nil && s.DB.Loaded() {
+		status.Loaded, status.BuildEpoch = true, s.DB.BuildEpoch()
+	}
+	if s.Servers.Enabled() {
+		status.Servers = s.Servers.Status()
+	}
+	return status
+}
+
+// Lookup 判断地址是否为内网地址，分别查询其作为客户端与服务器时的地理位置及匹配的策略规则
+func (s *GeoRoute) Lookup(ip netip.Addr) interface{} {
+	lookup := geoLookup{Internal: isInternalIP(ip), Location: s.getClientLocation(ip), Server: s.getServerLocation(ip)}
+	if !lookup.Internal {
+		info := s.clientInfo(ip, lookup.Location)
+		lookup.ASN, lookup.Policy = info.ASN, s.Policy.matchClient(info)
+	}
+	return lookup
+}
+
+// CacheStats 地理位置缓存统计
+func (s *GeoRoute) CacheStats() interface{} {
+	stats := common.CacheStats{Hits: s.cacheHits.Load(), Misses: s.cacheMisses.Load()}
+	if s.LocationCache != nil {
+		stats.Size = s.LocationCache.Len()
+		stats.Capacity = s.CacheSize
+		if stats.Capacity <= 0 {
+			stats.Capacity = 1024
+		}
+	}
+	return stats
+}
@@ -0,0 +1,155 @@
+package georoute
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// errNoDatabase GeoIP 数据库尚未加载
+var errNoDatabase = errors.New("GeoIP database not loaded")
+
+// geoReader 一个已打开的数据库版本，按引用计数关闭：
+// 初始持有 1 个引用（当前版本），每次查询期间持有 1 个引用，被替换后释放初始引用，最后一个查询结束时关闭
+type geoReader struct {
+	reader *geoip2.Reader
+	refs   atomic.Int64
+}
+
+// acquire 获取引用，已关闭时返回 false
+func (g *geoReader) acquire() bool {
+	for {
+		n := g.refs.Load()
+		if n <= 0 {
+			return false
+		}
+		if g.refs.CompareAndSwap(n, n+1) {
+			return true
+		}
+	}
+}
+
+// release 释放引用，最后一个引用释放时关闭数据库（解除 mmap）
+func (g *geoReader) release() {
+	if g.refs.Add(-1) == 0 {
+		g.reader.Close()
+	}
+}
+
+// GeoDB 可热加载的 GeoIP2 数据库：按修改时间轮询文件，变化时打开新版本并原子替换，
+// 旧版本在进行中的查询结束后关闭
+type GeoDB struct {
+	Path     string              // 数据库文件路径
+	Interval time.Duration       // 文件检查间隔，0 表示只在启动时加载
+	Log      *common.Logger      // 插件日志
+	OnLoad   func(*GeoDB)        // 加载新版本后回调，用于清空地理位置缓存
+	File     *common.FileWatcher // 数据库文件监听
+
+	current atomic.Pointer[geoReader]
+}
+
+// Start 同步加载数据库，并按间隔检查文件变化
+func (d *GeoDB) Start() {
+	d.File = &common.FileWatcher{
+		Log:      d.Log,
+		Path:     d.Path,
+		Interval: d.Interval,
+		OnChange: d.load,
+	}
+	if d.Interval > 0 {
+		d.File.Start()
+		return
+	}
+	d.File.Check()
+}
+
+// Stop 停止检查并关闭当前版本
+func (d *GeoDB) Stop() error {
+	if d.File != nil {
+		d.File.Stop()
+	}
+	if old := d.current.Swap(nil); old != nil {
+		old.release()
+	}
+	return nil
+}
+
+// load 打开新版本并替换当前版本；打开失败（如文件正在写入）时保留当前版本
+func (d *GeoDB) load() error {
+	reader, err := geoip2.Open(d.Path)
+	if err != nil {
+		reloadCount.WithLabelValues("failure").Inc()
+		return err
+	}
+	g := &geoReader{reader: reader}
+	g.refs.Store(1)
+	old := d.current.Swap(g)
+	if old != nil {
+		old.release()
+	}
+	meta := reader.Metadata()
+	reloadCount.WithLabelValues("success").Inc()
+	dbBuildEpoch.WithLabelValues(meta.DatabaseType).Set(float64(meta.BuildEpoch))
+	d.Log.Infof("GeoIP database loaded: %s (%s, built %s)", d.Path, meta.DatabaseType,
+		time.Unix(int64(meta.BuildEpoch), 0).UTC().Format(time.RFC3339))
+	if d.OnLoad != nil {
+		d.OnLoad(d)
+	}
+	return nil
+}
+
+// Loaded 是否已成功加载
+func (d *GeoDB) Loaded() bool {
+	return d.current.Load() != nil
+}
+
+// BuildEpoch 当前版本的构建时间，未加载时为零值
+func (d *GeoDB) BuildEpoch() time.Time {
+	var epoch time.Time
+	d.with(func(r *geoip2.Reader) error {
+		epoch = time.Unix(int64(r.Metadata().BuildEpoch), 0).UTC()
+		return nil
+	})
+	return epoch
+}
+
+// with 持有当前版本的引用执行 fn，保证查询期间数据库不会被关闭
+func (d *GeoDB) with(fn func(*geoip2.Reader) error) error {
+	for {
+		g := d.current.Load()
+		if g == nil {
+			return errNoDatabase
+		}
+		// 获取引用与替换并发时旧版本可能已关闭，重新读取当前版本
+		if g.acquire() {
+			defer g.release()
+			return fn(g.reader)
+		}
+	}
+}
+
+// City 查询城市级地理位置
+func (d *GeoDB) City(ip []byte) (*geoip2.City, error) {
+	var record *geoip2.City
+	err := d.with(func(r *geoip2.Reader) error {
+		var err error
+		record, err = r.City(ip)
+		return err
+	})
+	return record, err
+}
+
+// ASN 查询自治系统号，需要 GeoLite2-ASN 数据库
+func (d *GeoDB) ASN(ip []byte) (*geoip2.ASN, error) {
+	var record *geoip2.ASN
+	err := d.with(func(r *geoip2.Reader) error {
+		var err error
+		record, err = r.ASN(ip)
+		return err
+	})
+	return record, err
+}
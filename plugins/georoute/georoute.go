@@ -2,12 +2,17 @@ package georoute
 
 import (
 	"context"
-	"log"
 	"math"
 	"net"
-	"strings"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"coredns-plugins/plugins/common"
 
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/miekg/dns"
 	"github.com/oschwald/geoip2-golang"
@@ -15,127 +20,224 @@ import (
 
 // GeoLocation 地理位置信息
 type GeoLocation struct {
-	Country   string  `json:"country"`
-	Region    string  `json:"region"`
-	City      string  `json:"city"`
-	Latitude  float64 `json:"latitude"`
-	Longitude float64 `json:"longitude"`
+	Country     string  `json:"country"`
+	CountryCode string  `json:"country_code,omitempty"` // ISO 3166-1 国家代码，用于策略路由
+	Continent   string  `json:"continent,omitempty"`    // 大洲代码，用于策略路由
+	Region      string  `json:"region"`
+	City        string  `json:"city"`
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	Datacenter  string  `json:"datacenter,omitempty"` // 数据中心标签，仅服务器位置表配置
 }
 
 // GeoRoute 基于地理位置的就近解析插件
 type GeoRoute struct {
 	Next              plugin.Handler
-	GeoIPDBPath       string         // GeoIP2数据库路径
-	GeoIPReader       *geoip2.Reader // GeoIP2数据库读取器
-	LocationCache     *lru.Cache     // 地理位置缓存
-	CacheSize         int            // 缓存大小
-	DistanceThreshold float64        // 距离阈值（公里）
-	InternalRanges    []*net.IPNet   // 内网IP范围
-}
-
-// responseCaptureWriter 捕获下游插件响应
-type responseCaptureWriter struct {
-	dns.ResponseWriter
-	Msg *dns.Msg
-}
-
-func (r *responseCaptureWriter) WriteMsg(res *dns.Msg) error {
-	r.Msg = res
-	return nil // 不直接写出，由 georoute 处理
+	GeoIPDBPath       string               // GeoIP2数据库路径
+	Servers           ServerLocations      // 服务器位置表，优先于 GeoIP 数据库
+	ASNDBPath         string               // GeoLite2-ASN 数据库路径，策略路由按 ASN 匹配时需要
+	ASNDB             *GeoDB               // ASN 数据库，文件更新后自动热加载
+	Policy            Policy               // 策略路由规则，先于就近选择匹配
+	DB                *GeoDB               // GeoIP2数据库，文件更新后自动热加载
+	ReloadInterval    time.Duration        // 数据库文件检查间隔，0 表示不热加载
+	LocationCache     *lru.Cache           // 地理位置缓存
+	CacheSize         int                  // 缓存大小
+	DistanceThreshold float64              // 距离阈值（公里），Rank 为 threshold 时生效
+	Rank              string               // 就近选择方式：threshold、nearest、within
+	NearestN          int                  // nearest 模式返回的服务器数
+	WithinPercent     float64              // within 模式相对最近服务器距离的百分比
+	WithinMinKm       float64              // within 模式的最小距离范围（公里）
+	InternalRanges    []*net.IPNet         // 内网IP范围
+	Answers           common.AnswerConfig  // 选中服务器的排列方式与数量上限，未配置排列方式时保持由近到远的顺序
+	TTL               common.TTLPolicy     // 按决策类型限制返回记录的 TTL
+	DNSSEC            common.DNSSEC        // 下游返回已签名记录时的处理方式
+	EcsTrusted        common.ECSTrusted    // 允许携带 ECS 的递归解析器网段
+	Health            common.HealthChecker // 后端健康检查，未配置规则时不过滤
+	Log               *common.Logger       // 插件日志
+	AdminAddr         string               // 管理接口监听地址，为空时不启用
+
+	cacheHits   atomic.Uint64 // 缓存命中次数，用于管理接口展示
+	cacheMisses atomic.Uint64
 }
 
 // ServeDNS 处理DNS请求
 func (s *GeoRoute) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	// 捕获下游插件的响应
-	rw := &responseCaptureWriter{ResponseWriter: w}
+	// 捕获下游插件的响应，在该响应上原地筛选
+	rw := common.NewResponseCaptureWriter(w)
 	code, err := plugin.NextOrFailure(s.Name(), s.Next, ctx, rw, r)
-	if err != nil || rw.Msg == nil || len(rw.Msg.Answer) == 0 {
+	if err != nil || rw.Msg == nil {
 		return code, err
 	}
 
-	// 仅有一个地址时直接返回
-	if len(rw.Msg.Answer) == 1 {
+	// 只筛选 CNAME 链末端的 A/AAAA 记录；仅有一个地址或签名记录不能过滤时直接返回
+	addrs, others := common.AddrRRs(rw.Msg)
+	if len(addrs) <= 1 || s.DNSSEC.Skip(r, addrs, others) {
+		// 原样返回全部记录，按 fallback 层级限制 TTL；只调低 TTL，原签名仍然有效
+		common.CapTTL(rw.Msg, addrs, s.TTL.Cap("fallback", s.degraded()))
 		w.WriteMsg(rw.Msg)
-		return dns.RcodeSuccess, nil
+		return code, err
 	}
 
-	clientIP := getClientIP(w.RemoteAddr().String())
+	clientIP, ecs := common.ClientSubnet(w, r, s.EcsTrusted)
 	clientLocation := s.getClientLocation(clientIP)
 	isInternal := isInternalIP(clientIP)
 
-	log.Printf("[georoute] clientIP=%s, isInternal=%v, location=%+v", clientIP, isInternal, clientLocation)
+	verbose := s.Log.Sampled()
 
-	// 根据地理位置优选解析结果
+	// 剔除不健康的后端
 	var filteredAnswers []dns.RR
-	var allIPs []string
-	var selectedIPs []string
-
-	for _, rr := range rw.Msg.Answer {
-		switch v := rr.(type) {
-		case *dns.A:
-			allIPs = append(allIPs, v.A.String())
-			if s.isPreferredServer(v.A.String(), clientLocation, isInternal) {
-				filteredAnswers = append(filteredAnswers, rr)
-				selectedIPs = append(selectedIPs, v.A.String())
-			}
-		case *dns.AAAA:
-			allIPs = append(allIPs, v.AAAA.String())
-			if s.isPreferredServer(v.AAAA.String(), clientLocation, isInternal) {
-				filteredAnswers = append(filteredAnswers, rr)
-				selectedIPs = append(selectedIPs, v.AAAA.String())
+	var cands []candidate
+	answers := s.Health.Filter(addrs)
+	for _, rr := range answers {
+		ip, _ := common.RRAddr(rr)
+		cands = append(cands, candidate{rr: rr, ip: ip})
+	}
+
+	server := metrics.WithServer(ctx)
+	country := countryLabel(clientLocation, isInternal)
+	requestCount.WithLabelValues(server, country).Inc()
+
+	// 内网客户端返回全部（由 azroute 处理可用区调度）；外网客户端先匹配策略路由规则，
+	// 未命中时按距离排序后就近选择，无法定位时返回全部；应答中的记录保持由近到远的顺序
+	tier := "geo"
+	selected := cands
+	var rule string
+	if !isInternal {
+		rule, selected = s.applyPolicy(cands, clientIP, clientLocation)
+	}
+	switch {
+	case isInternal:
+		tier = "internal"
+	case rule != "":
+		tier = "policy"
+		policyCount.WithLabelValues(server, rule).Inc()
+	case clientLocation == nil:
+		tier, selected = "unknown", cands
+	default:
+		s.rankCandidates(cands, clientLocation)
+		selected = s.selectCandidates(cands)
+	}
+	// 没有选中的服务器时按距离顺序返回全部
+	if len(selected) == 0 {
+		tier, selected = "fallback", cands
+		fallbackCount.WithLabelValues(server).Inc()
+	}
+	for _, c := range selected {
+		filteredAnswers = append(filteredAnswers, c.rr)
+	}
+	filteredAnswers = s.Answers.Select(s.Answers.Key(r, s.Answers.ClientKey(clientIP, ecs)), filteredAnswers, nil)
+	degraded := s.degraded()
+	ttl := s.TTL.Cap(tier, degraded)
+	// 模拟解析（管理接口 dryrun）时无论是否采样都记录决策
+	if trace := common.TraceFrom(ctx); verbose || trace != nil {
+		d := common.NewDecision(clientIP, ecs != nil, r, tier, filteredAnswers)
+		d.Attrs = map[string]string{"country": country}
+		d.SetTTL(ttl, degraded)
+		if rule != "" {
+			d.Attrs["policy"] = rule
+		}
+		if tier == "geo" && !math.IsInf(selected[0].distance, 1) {
+			d.Attrs["nearest_km"] = strconv.FormatFloat(selected[0].distance, 'f', 0, 64)
+			if dc := selected[0].location.Datacenter; dc != "" {
+				d.Attrs["nearest_dc"] = dc
 			}
-		default:
-			// 其他类型直接透传
-			filteredAnswers = append(filteredAnswers, rr)
 		}
+		if verbose {
+			s.Log.Debugf("clientIP=%s, isInternal=%v, location=%+v, hosts returned IPs: %v, final returned IPs: %v, tier=%s",
+				clientIP, isInternal, clientLocation, common.Addrs(answers), common.Addrs(filteredAnswers), tier)
+			s.Log.Decision(d)
+		}
+		trace.Add(s.Name(), d)
 	}
 
-	log.Printf("[georoute] hosts returned IPs: %v", allIPs)
-	log.Printf("[georoute] selected IPs: %v", selectedIPs)
+	m := rw.Msg
+	if ecs != nil {
+		// GeoIP 定位粒度未知，按 SOURCE 长度作为作用范围
+		common.SetECSScope(m, r, m, ecs, ecs.SourceNetmask)
+	}
+	fitted, signErr := common.SetFiltered(w, r, m, &s.DNSSEC, others, addrs, filteredAnswers)
+	if signErr != nil {
+		s.Log.Warningf("%v", signErr)
+	}
+	common.CapTTL(m, fitted, ttl)
+	if verbose && len(fitted) < len(filteredAnswers) {
+		s.Log.Debugf("answers trimmed from %d to %d to fit UDP size", len(filteredAnswers), len(fitted))
+	}
+	w.WriteMsg(m)
+	return code, err
+}
 
-	// 如果没有匹配的结果，返回全部
-	if len(filteredAnswers) == 0 {
-		filteredAnswers = rw.Msg.Answer
-		log.Printf("[georoute] no preferred servers found, returning all IPs")
+// degraded GeoIP 数据库未加载或服务器位置 API 拉取失败时定位可能不准确，按降级上限缩短 TTL 以便尽快重新解析
+func (s *GeoRoute) degraded() bool {
+	return s.DB == nil || !s.DB.Loaded() || s.Servers.Fetcher.Degraded()
+}
+
+// applyPolicy 按策略路由规则筛选服务器，返回命中的规则名称与选中的服务器（客户端位置已知时按距离排序）；
+// 没有规则命中或命中规则的服务器都不在应答中时返回空
+func (s *GeoRoute) applyPolicy(cands []candidate, clientIP netip.Addr, location *GeoLocation) (string, []candidate) {
+	if s.Policy.Rules() == 0 || len(cands) == 0 {
+		return "", nil
 	}
+	rule, pool := s.Policy.match(s.clientInfo(clientIP, location), cands, s.locate)
+	if rule != "" && location != nil {
+		s.rankCandidates(pool, location)
+	}
+	return rule, pool
+}
 
-	var retIPs []string
-	for _, rr := range filteredAnswers {
-		switch v := rr.(type) {
-		case *dns.A:
-			retIPs = append(retIPs, v.A.String())
-		case *dns.AAAA:
-			retIPs = append(retIPs, v.AAAA.String())
-		}
+// clientInfo 策略匹配使用的客户端国家、大洲与 ASN
+func (s *GeoRoute) clientInfo(ip netip.Addr, location *GeoLocation) clientInfo {
+	var info clientInfo
+	if location != nil {
+		info.Country, info.Continent = location.CountryCode, location.Continent
 	}
-	log.Printf("[georoute] final returned IPs: %v", retIPs)
+	info.ASN = s.getClientASN(ip)
+	return info
+}
 
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Answer = filteredAnswers
-	w.WriteMsg(m)
-	return dns.RcodeSuccess, nil
+// getClientASN 查询客户端自治系统号，未配置 ASN 数据库或查询失败时返回 0
+func (s *GeoRoute) getClientASN(ip netip.Addr) uint {
+	if s.ASNDB == nil || !s.ASNDB.Loaded() || !ip.IsValid() {
+		return 0
+	}
+	cacheKey := "asn:" + ip.String()
+	if s.LocationCache != nil {
+		if v, ok := s.LocationCache.Get(cacheKey); ok {
+			s.observeCache(true)
+			return v.(uint)
+		}
+		s.observeCache(false)
+	}
+	record, err := s.ASNDB.ASN(ip.AsSlice())
+	if err != nil {
+		s.Log.Debugf("ASN lookup failed for %s: %v", ip, err)
+		return 0
+	}
+	if s.LocationCache != nil {
+		s.LocationCache.Add(cacheKey, record.AutonomousSystemNumber)
+	}
+	return record.AutonomousSystemNumber
 }
 
-// getClientIP 提取客户端IP
-func getClientIP(addr string) string {
-	if strings.Contains(addr, "[") { // IPv6
-		addr = strings.Split(addr, "]:")[0]
-		addr = strings.TrimPrefix(addr, "[")
-	} else {
-		addr = strings.Split(addr, ":")[0]
+// countryLabel 请求指标中的国家标签
+func countryLabel(location *GeoLocation, isInternal bool) string {
+	if isInternal {
+		return "internal"
+	}
+	if location == nil || location.Country == "" {
+		return "unknown"
 	}
-	return addr
+	return location.Country
 }
 
 // isInternalIP 判断是否为内网IP（静态通用版，适合无网段动态配置场景）
-func isInternalIP(ip string) bool {
-	ipAddr := net.ParseIP(ip)
-	if ipAddr == nil {
+func isInternalIP(ip netip.Addr) bool {
+	if !ip.IsValid() {
 		return false
 	}
 	// IPv4
-	if ip4 := ipAddr.To4(); ip4 != nil {
+	if ip.Is4() {
+		ip4 := ip.As4()
 		switch {
 		case ip4[0] == 10:
 			return true
@@ -148,49 +250,35 @@ func isInternalIP(ip string) bool {
 		}
 	}
 	// IPv6
-	if ipAddr.IsLoopback() || ipAddr.IsLinkLocalUnicast() || ipAddr.IsLinkLocalMulticast() {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
 		return true
 	}
 	return false
 }
 
 // getClientLocation 获取客户端地理位置
-func (s *GeoRoute) getClientLocation(ip string) *GeoLocation {
+func (s *GeoRoute) getClientLocation(ip netip.Addr) *GeoLocation {
 	// 先查缓存
 	if s.LocationCache != nil {
 		if v, ok := s.LocationCache.Get(ip); ok {
+			s.observeCache(true)
 			return v.(*GeoLocation)
 		}
+		s.observeCache(false)
 	}
 
-	if s.GeoIPReader == nil {
-		return nil
-	}
-
-	ipAddr := net.ParseIP(ip)
-	if ipAddr == nil {
+	if s.DB == nil || !s.DB.Loaded() || !ip.IsValid() {
 		return nil
 	}
 
 	// 查询GeoIP2数据库
-	record, err := s.GeoIPReader.City(ipAddr)
+	record, err := s.DB.City(ip.AsSlice())
 	if err != nil {
-		log.Printf("[georoute] GeoIP lookup failed for %s: %v", ip, err)
+		s.Log.Debugf("GeoIP lookup failed for %s: %v", ip, err)
 		return nil
 	}
 
-	location := &GeoLocation{
-		Country:   record.Country.Names["en"],
-		Region:    "",
-		City:      record.City.Names["en"],
-		Latitude:  record.Location.Latitude,
-		Longitude: record.Location.Longitude,
-	}
-
-	// 获取地区信息（如果有的话）
-	if len(record.Subdivisions) > 0 {
-		location.Region = record.Subdivisions[0].Names["en"]
-	}
+	location := newGeoLocation(record)
 
 	// 缓存结果
 	if s.LocationCache != nil {
@@ -200,91 +288,83 @@ func (s *GeoRoute) getClientLocation(ip string) *GeoLocation {
 	return location
 }
 
-// getServerLocation 获取服务器地理位置
-func (s *GeoRoute) getServerLocation(serverIP string) *GeoLocation {
-	// 先查缓存
-	if s.LocationCache != nil {
-		cacheKey := "server:" + serverIP
-		if v, ok := s.LocationCache.Get(cacheKey); ok {
-			return v.(*GeoLocation)
-		}
-	}
-
-	if s.GeoIPReader == nil {
-		return nil
-	}
-
-	ipAddr := net.ParseIP(serverIP)
-	if ipAddr == nil {
-		return nil
+// observeCache 记录一次 LocationCache 查询结果
+func (s *GeoRoute) observeCache(hit bool) {
+	if hit {
+		s.cacheHits.Add(1)
+		cacheHits.Inc()
+		return
 	}
+	s.cacheMisses.Add(1)
+	cacheMisses.Inc()
+}
 
-	// 查询GeoIP2数据库
-	record, err := s.GeoIPReader.City(ipAddr)
-	if err != nil {
-		log.Printf("[georoute] GeoIP lookup failed for server %s: %v", serverIP, err)
+// newGeoLocation 由 GeoIP2 记录生成地理位置；数据库中没有该地址时记录为空，返回 nil 表示位置未知
+func newGeoLocation(record *geoip2.City) *GeoLocation {
+	if record.Location.Latitude == 0 && record.Location.Longitude == 0 && record.Country.IsoCode == "" {
 		return nil
 	}
-
 	location := &GeoLocation{
-		Country:   record.Country.Names["en"],
-		Region:    "",
-		City:      record.City.Names["en"],
-		Latitude:  record.Location.Latitude,
-		Longitude: record.Location.Longitude,
+		Country:     record.Country.Names["en"],
+		CountryCode: record.Country.IsoCode,
+		Continent:   record.Continent.Code,
+		Region:      "",
+		City:        record.City.Names["en"],
+		Latitude:    record.Location.Latitude,
+		Longitude:   record.Location.Longitude,
 	}
 
 	// 获取地区信息（如果有的话）
 	if len(record.Subdivisions) > 0 {
 		location.Region = record.Subdivisions[0].Names["en"]
 	}
+	return location
+}
 
-	// 缓存结果
-	if s.LocationCache != nil {
-		cacheKey := "server:" + serverIP
-		s.LocationCache.Add(cacheKey, location)
+// locate 查询候选服务器的位置，每个候选在一次查询内只查询一次
+func (s *GeoRoute) locate(c *candidate) *GeoLocation {
+	if !c.located {
+		c.location, c.located = s.getServerLocation(c.ip), true
 	}
-
-	return location
+	return c.location
 }
 
-// isPreferredServer 判断是否为优选服务器
-func (s *GeoRoute) isPreferredServer(serverIP string, clientLocation *GeoLocation, isInternal bool) bool {
-	// 如果是内网IP，直接返回（由azroute插件处理可用区调度）
-	if isInternal {
-		log.Printf("[georoute] client is internal IP, returning server for azroute processing: %s", serverIP)
-		return true
+// getServerLocation 获取服务器地理位置，服务器位置表优先于 GeoIP 数据库
+func (s *GeoRoute) getServerLocation(serverIP netip.Addr) *GeoLocation {
+	if location := s.Servers.Lookup(serverIP); location != nil {
+		return location
 	}
 
-	// 如果无法获取客户端位置，返回所有服务器
-	if clientLocation == nil {
-		log.Printf("[georoute] cannot get client location, returning server: %s", serverIP)
-		return true
+	// 先查缓存
+	if s.LocationCache != nil {
+		cacheKey := "server:" + serverIP.String()
+		if v, ok := s.LocationCache.Get(cacheKey); ok {
+			s.observeCache(true)
+			return v.(*GeoLocation)
+		}
+		s.observeCache(false)
 	}
 
-	// 获取服务器位置信息
-	serverLocation := s.getServerLocation(serverIP)
-	if serverLocation == nil {
-		log.Printf("[georoute] cannot get server location, returning server: %s", serverIP)
-		return true
+	if s.DB == nil || !s.DB.Loaded() || !serverIP.IsValid() {
+		return nil
 	}
 
-	// 计算距离
-	distance := calculateDistance(
-		clientLocation.Latitude, clientLocation.Longitude,
-		serverLocation.Latitude, serverLocation.Longitude,
-	)
+	// 查询GeoIP2数据库
+	record, err := s.DB.City(serverIP.AsSlice())
+	if err != nil {
+		s.Log.Debugf("GeoIP lookup failed for server %s: %v", serverIP, err)
+		return nil
+	}
 
-	log.Printf("[georoute] server=%s, distance=%.2fkm, threshold=%.2fkm", serverIP, distance, s.DistanceThreshold)
+	location := newGeoLocation(record)
 
-	// 根据距离阈值判断
-	if distance <= s.DistanceThreshold {
-		log.Printf("[georoute] server %s is within distance threshold", serverIP)
-		return true
+	// 缓存结果
+	if s.LocationCache != nil {
+		cacheKey := "server:" + serverIP.String()
+		s.LocationCache.Add(cacheKey, location)
 	}
 
-	log.Printf("[georoute] server %s is too far, distance=%.2fkm", serverIP, distance)
-	return false
+	return location
 }
 
 // calculateDistance 计算两点间距离（公里）
@@ -308,19 +388,21 @@ func calculateDistance(lat1, lon1, lat2, lon2 float64) float64 {
 // Name 插件名称
 func (s *GeoRoute) Name() string { return "georoute" }
 
-// InitGeoRoute 初始化GeoRoute插件
-func (s *GeoRoute) InitGeoRoute() {
-	// 初始化GeoIP2数据库
-	if s.GeoIPDBPath != "" {
-		reader, err := geoip2.Open(s.GeoIPDBPath)
-		if err != nil {
-			log.Printf("[georoute] Failed to open GeoIP database: %v", err)
-		} else {
-			s.GeoIPReader = reader
-			log.Printf("[georoute] GeoIP database loaded: %s", s.GeoIPDBPath)
-		}
+// Stop 停止数据库文件检查并关闭数据库
+func (s *GeoRoute) Stop() error {
+	if s.DB != nil {
+		s.DB.Stop()
+	}
+	if s.ASNDB != nil {
+		s.ASNDB.Stop()
 	}
+	s.Servers.Stop()
+	s.Policy.Stop()
+	return nil
+}
 
+// InitGeoRoute 初始化GeoRoute插件
+func (s *GeoRoute) InitGeoRoute() {
 	// 初始化内网IP范围
 	s.InternalRanges = []*net.IPNet{
 		{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)},     // 10.0.0.0/8
@@ -336,15 +418,57 @@ func (s *GeoRoute) InitGeoRoute() {
 	}
 	cache, err := lru.New(cacheSize)
 	if err != nil {
-		log.Printf("[georoute] LRU缓存初始化失败: %v", err)
+		s.Log.Warningf("LRU缓存初始化失败: %v", err)
 	} else {
 		s.LocationCache = cache
 	}
 
+	// 初始化GeoIP2数据库，新版本加载后清空地理位置缓存
+	purge := func(*GeoDB) {
+		if s.LocationCache != nil {
+			s.LocationCache.Purge()
+		}
+	}
+	if s.GeoIPDBPath != "" {
+		s.DB = &GeoDB{
+			Path:     s.GeoIPDBPath,
+			Interval: s.ReloadInterval,
+			Log:      s.Log,
+			OnLoad:   purge,
+		}
+		s.DB.Start()
+	}
+	if s.ASNDBPath != "" {
+		s.ASNDB = &GeoDB{
+			Path:     s.ASNDBPath,
+			Interval: s.ReloadInterval,
+			Log:      s.Log,
+			OnLoad:   purge,
+		}
+		s.ASNDB.Start()
+	}
+
+	// 加载服务器位置表
+	if s.Servers.Enabled() {
+		s.Servers.Log = s.Log
+		s.Servers.CacheSize = s.CacheSize
+		s.Servers.Start()
+	}
+
+	// 加载策略路由规则（内联规则已在解析配置时校验）
+	s.Policy.Log = s.Log
+	if err := s.Policy.Start(); err != nil {
+		s.Log.Errorf("policy rules not loaded: %v", err)
+	}
+
+	if s.Rank == "" {
+		s.Rank = RankThreshold
+	}
+
 	// 设置默认距离阈值
 	if s.DistanceThreshold <= 0 {
 		s.DistanceThreshold = 1000 // 默认1000公里
 	}
 
-	log.Printf("[georoute] GeoRoute plugin initialized with distance threshold: %.2fkm", s.DistanceThreshold)
+	s.Log.Infof("GeoRoute plugin initialized with rank %s, distance threshold: %.2fkm", s.Rank, s.DistanceThreshold)
 }
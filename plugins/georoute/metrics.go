@@ -0,0 +1,91 @@
+package georoute
+
+import (
+	"errors"
+	"time"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// requestCount 按客户端所在国家统计的请求数，内网客户端记为 internal，无法定位记为 unknown
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "requests_total",
+		Help:      "Counter of requests filtered by georoute, by client country.",
+	}, []string{"server", "country"})
+	// fallbackCount 没有就近服务器、回退返回全部记录的次数
+	fallbackCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "fallback_total",
+		Help:      "Counter of requests that fell back to returning all records.",
+	}, []string{"server"})
+	// policyCount 命中策略路由规则的请求数
+	policyCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "policy_total",
+		Help:      "Counter of requests routed by a policy rule, by rule name.",
+	}, []string{"server", "rule"})
+	// reloadCount GeoIP 数据库加载次数，result 为 success/failure
+	reloadCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "db_reloads_total",
+		Help:      "Counter of GeoIP database loads, by result.",
+	}, []string{"result"})
+	// dbBuildEpoch 当前加载的 GeoIP 数据库构建时间（Unix 秒）
+	dbBuildEpoch = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "db_build_epoch_seconds",
+		Help:      "Build epoch of the loaded GeoIP database.",
+	}, []string{"type"})
+	// cacheHits LocationCache 命中次数
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "cache_hits_total",
+		Help:      "Counter of location lookups served from the LRU cache.",
+	})
+	// cacheMisses LocationCache 未命中次数
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "cache_misses_total",
+		Help:      "Counter of location lookups that missed the LRU cache.",
+	})
+	// serverEntries 当前加载的服务器位置网段数
+	serverEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "server_locations",
+		Help:      "The number of server location overrides currently loaded.",
+	})
+	// serverFetchCount 服务器位置 API 拉取次数，按结果区分
+	serverFetchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "georoute",
+		Name:      "server_fetch_total",
+		Help:      "Counter of server location API fetches, by result.",
+	}, []string{"result"})
+)
+
+// observeServerFetch 统计服务器位置 API 拉取结果
+func observeServerFetch(err error, _ time.Duration) {
+	switch {
+	case err == nil:
+		serverFetchCount.WithLabelValues("success").Inc()
+	case errors.Is(err, common.ErrNotModified):
+		serverFetchCount.WithLabelValues("unchanged").Inc()
+	default:
+		serverFetchCount.WithLabelValues("failure").Inc()
+	}
+}
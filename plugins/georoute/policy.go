@@ -0,0 +1,314 @@
+package georoute
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"coredns-plugins/plugins/common"
+)
+
+// continents GeoIP2 大洲代码
+var continents = map[string]bool{"AF": true, "AN": true, "AS": true, "EU": true, "NA": true, "OC": true, "SA": true}
+
+// PolicyRule 策略路由规则：客户端满足条件时只返回选中的服务器。
+// 同类条件之间为“或”，不同类条件之间为“且”，没有条件的规则匹配所有外网客户端
+type PolicyRule struct {
+	Name       string   `json:"name,omitempty"`      // 规则名称，用于指标与决策日志，默认为来源与序号
+	Countries  []string `json:"country,omitempty"`   // 客户端国家代码（ISO 3166-1，如 CN）
+	Continents []string `json:"continent,omitempty"` // 客户端大洲代码（如 EU）
+	ASNs       []uint   `json:"asn,omitempty"`       // 客户端自治系统号，需要配置 asn_db
+	Servers    []string `json:"servers"`             // 服务器选择：数据中心标签通配符（如 cn-*）或网段/IP
+}
+
+// policyRule 编译后的规则
+type policyRule struct {
+	name       string
+	countries  map[string]bool
+	continents map[string]bool
+	asns       map[uint]bool
+	patterns   []string       // 数据中心标签通配符，语法同 path.Match
+	prefixes   []netip.Prefix // 服务器网段
+}
+
+// clientInfo 策略匹配使用的客户端属性
+type clientInfo struct {
+	Country   string
+	Continent string
+	ASN       uint
+}
+
+// Policy 策略路由规则集：Corefile 内联规则在前，策略文件规则在后，按顺序匹配；策略文件变化时热加载
+type Policy struct {
+	Inline   []PolicyRule        // Corefile 内联规则
+	FilePath string              // 策略文件路径（JSON/YAML）
+	File     *common.FileWatcher // 策略文件监听
+	Log      *common.Logger      // 插件日志
+	ASN      bool                // 是否配置了 ASN 数据库，未配置时带 ASN 条件的规则永远不会命中
+
+	inline []*policyRule                 // 编译后的内联规则
+	rules  atomic.Pointer[[]*policyRule] // 生效的全部规则，热加载时原子替换
+}
+
+// parsePolicyRule 解析内联规则：policy [name=NAME] [country=CC,...] [continent=CC,...] [asn=N,...] -> SERVER...
+func parsePolicyRule(args []string) (PolicyRule, error) {
+	var rule PolicyRule
+	i := 0
+	for ; i < len(args) && args[i] != "->"; i++ {
+		key, value, ok := strings.Cut(args[i], "=")
+		if !ok || value == "" {
+			return rule, fmt.Errorf("invalid condition: %s", args[i])
+		}
+		values := strings.Split(value, ",")
+		switch key {
+		case "name":
+			rule.Name = value
+		case "country":
+			rule.Countries = append(rule.Countries, values...)
+		case "continent":
+			rule.Continents = append(rule.Continents, values...)
+		case "asn":
+			for _, v := range values {
+				asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(v), "AS"), 10, 32)
+				if err != nil {
+					return rule, fmt.Errorf("invalid asn: %s", v)
+				}
+				rule.ASNs = append(rule.ASNs, uint(asn))
+			}
+		default:
+			return rule, fmt.Errorf("unknown condition: %s", key)
+		}
+	}
+	if i == len(args) || i == len(args)-1 {
+		return rule, fmt.Errorf("requires -> SERVER...")
+	}
+	rule.Servers = args[i+1:]
+	return rule, nil
+}
+
+// compile 校验并编译规则
+func (r PolicyRule) compile(name string) (*policyRule, error) {
+	if r.Name != "" {
+		name = r.Name
+	}
+	if len(r.Servers) == 0 {
+		return nil, fmt.Errorf("rule %s: no servers", name)
+	}
+	rule := &policyRule{name: name}
+	for _, c := range r.Countries {
+		c = strings.ToUpper(c)
+		if len(c) != 2 {
+			return nil, fmt.Errorf("rule %s: invalid country: %s", name, c)
+		}
+		if rule.countries == nil {
+			rule.countries = make(map[string]bool)
+		}
+		rule.countries[c] = true
+	}
+	for _, c := range r.Continents {
+		c = strings.ToUpper(c)
+		if !continents[c] {
+			return nil, fmt.Errorf("rule %s: invalid continent: %s", name, c)
+		}
+		if rule.continents == nil {
+			rule.continents = make(map[string]bool)
+		}
+		rule.continents[c] = true
+	}
+	for _, asn := range r.ASNs {
+		if rule.asns == nil {
+			rule.asns = make(map[uint]bool)
+		}
+		rule.asns[asn] = true
+	}
+	for _, server := range r.Servers {
+		if prefix, err := common.ParsePrefix(server); err == nil {
+			rule.prefixes = append(rule.prefixes, prefix)
+			continue
+		}
+		if _, err := path.Match(server, ""); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid server pattern: %s", name, server)
+		}
+		rule.patterns = append(rule.patterns, server)
+	}
+	return rule, nil
+}
+
+// compileRules 编译一组规则，未命名的规则以 来源#序号 命名
+func compileRules(source string, rules []PolicyRule) ([]*policyRule, error) {
+	compiled := make([]*policyRule, 0, len(rules))
+	for i, r := range rules {
+		rule, err := r.compile(fmt.Sprintf("%s#%d", source, i+1))
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// matchClient 客户端是否满足规则条件
+func (r *policyRule) matchClient(client clientInfo) bool {
+	if r.countries != nil && !r.countries[client.Country] {
+		return false
+	}
+	if r.continents != nil && !r.continents[client.Continent] {
+		return false
+	}
+	if r.asns != nil && !r.asns[client.ASN] {
+		return false
+	}
+	return true
+}
+
+// matchServer 服务器是否被规则选中：IP 落在规则网段内，或数据中心标签匹配通配符；
+// 只有网段不匹配且规则有数据中心通配符时才通过 locate 查询服务器位置
+func (r *policyRule) matchServer(c *candidate, locate func(*candidate) *GeoLocation) bool {
+	for _, prefix := range r.prefixes {
+		if prefix.Contains(c.ip) {
+			return true
+		}
+	}
+	if len(r.patterns) == 0 {
+		return false
+	}
+	location := locate(c)
+	if location == nil || location.Datacenter == "" {
+		return false
+	}
+	for _, pattern := range r.patterns {
+		if ok, _ := path.Match(pattern, location.Datacenter); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// usesASN 规则中是否有 ASN 条件
+func usesASN(rules []PolicyRule) bool {
+	for _, r := range rules {
+		if len(r.ASNs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate 未配置 ASN 数据库时，内联规则或启动时的策略文件中不能有 ASN 条件
+func (p *Policy) Validate() error {
+	if p.ASN {
+		return nil
+	}
+	if usesASN(p.Inline) {
+		return errors.New("policy rule uses asn condition but asn_db is not configured")
+	}
+	if p.FilePath == "" {
+		return nil
+	}
+	// 文件暂不可读或格式错误时交给文件监听处理
+	body, err := os.ReadFile(p.FilePath)
+	if err != nil {
+		return nil
+	}
+	var rules []PolicyRule
+	if common.Decode(common.FormatOf(p.FilePath), body, &rules) == nil && usesASN(rules) {
+		return fmt.Errorf("policy file %s uses asn condition but asn_db is not configured", p.FilePath)
+	}
+	return nil
+}
+
+// Start 编译内联规则，并启动策略文件监听
+func (p *Policy) Start() error {
+	inline, err := compileRules("corefile", p.Inline)
+	if err != nil {
+		return err
+	}
+	p.inline = inline
+	p.rules.Store(&inline)
+	if p.FilePath != "" {
+		p.File = &common.FileWatcher{
+			Log:    p.Log,
+			Path:   p.FilePath,
+			OnData: p.loadFile,
+		}
+		p.File.Start()
+	}
+	return nil
+}
+
+// Stop 停止策略文件监听
+func (p *Policy) Stop() error {
+	if p.File != nil {
+		p.File.Stop()
+	}
+	return nil
+}
+
+// loadFile 解析策略文件并热加载；解析或校验失败时保留原规则
+func (p *Policy) loadFile(body []byte) error {
+	var rules []PolicyRule
+	if err := common.Decode(common.FormatOf(p.FilePath), body, &rules); err != nil {
+		return err
+	}
+	compiled, err := compileRules("file", rules)
+	if err != nil {
+		return err
+	}
+	if !p.ASN && usesASN(rules) {
+		p.Log.Warningf("policy file %s uses asn condition but asn_db is not configured, those rules never match", p.FilePath)
+	}
+	all := make([]*policyRule, 0, len(p.inline)+len(compiled))
+	all = append(all, p.inline...)
+	all = append(all, compiled...)
+	p.rules.Store(&all)
+	p.Log.Infof("policy file reloaded (%d rules)", len(compiled))
+	return nil
+}
+
+// Rules 当前生效的规则数
+func (p *Policy) Rules() int {
+	if rules := p.rules.Load(); rules != nil {
+		return len(*rules)
+	}
+	return 0
+}
+
+// match 按顺序匹配规则，返回第一条客户端满足条件且选中了至少一个服务器的规则及选中的服务器
+func (p *Policy) match(client clientInfo, cands []candidate, locate func(*candidate) *GeoLocation) (string, []candidate) {
+	rules := p.rules.Load()
+	if rules == nil {
+		return "", nil
+	}
+	for _, rule := range *rules {
+		if !rule.matchClient(client) {
+			continue
+		}
+		var pool []candidate
+		for i := range cands {
+			if rule.matchServer(&cands[i], locate) {
+				pool = append(pool, cands[i])
+			}
+		}
+		if len(pool) > 0 {
+			return rule.name, pool
+		}
+	}
+	return "", nil
+}
+
+// matchClient 返回第一条客户端满足条件的规则名称，用于管理接口查询
+func (p *Policy) matchClient(client clientInfo) string {
+	if rules := p.rules.Load(); rules != nil {
+		for _, rule := range *rules {
+			if rule.matchClient(client) {
+				return rule.name
+			}
+		}
+	}
+	return ""
+}
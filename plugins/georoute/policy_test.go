@@ -0,0 +1,180 @@
+package georoute
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"coredns-plugins/plugins/common"
+)
+
+func TestParsePolicyRule(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    PolicyRule
+		wantErr bool
+	}{
+		{
+			args: "name=cn country=CN,HK -> cn-*",
+			want: PolicyRule{Name: "cn", Countries: []string{"CN", "HK"}, Servers: []string{"cn-*"}},
+		},
+		{
+			args: "continent=EU asn=AS3320,6805 -> eu-* 198.51.100.0/24",
+			want: PolicyRule{Continents: []string{"EU"}, ASNs: []uint{3320, 6805}, Servers: []string{"eu-*", "198.51.100.0/24"}},
+		},
+		{args: "-> cn-*", want: PolicyRule{Servers: []string{"cn-*"}}},
+		{args: "country=CN", wantErr: true},
+		{args: "country=CN ->", wantErr: true},
+		{args: "country= -> cn-*", wantErr: true},
+		{args: "asn=ASX -> cn-*", wantErr: true},
+		{args: "city=beijing -> cn-*", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.args, func(t *testing.T) {
+			got, err := parsePolicyRule(strings.Fields(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePolicyRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePolicyRule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRuleCompile(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     PolicyRule
+		wantName string
+		wantErr  bool
+	}{
+		{name: "default name", rule: PolicyRule{Countries: []string{"cn"}, Servers: []string{"cn-*"}}, wantName: "corefile#1"},
+		{name: "named", rule: PolicyRule{Name: "eu", Continents: []string{"eu"}, Servers: []string{"eu-*"}}, wantName: "eu"},
+		{name: "invalid country", rule: PolicyRule{Countries: []string{"CHN"}, Servers: []string{"cn-*"}}, wantErr: true},
+		{name: "invalid continent", rule: PolicyRule{Continents: []string{"XX"}, Servers: []string{"cn-*"}}, wantErr: true},
+		{name: "invalid pattern", rule: PolicyRule{Servers: []string{"cn-["}}, wantErr: true},
+		{name: "no servers", rule: PolicyRule{Countries: []string{"CN"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := compileRules("corefile", []PolicyRule{tt.rule})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compileRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && rules[0].name != tt.wantName {
+				t.Errorf("name = %q, want %q", rules[0].name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestPolicyMatch(t *testing.T) {
+	p := &Policy{Log: common.NewLogger("georoute"), ASN: true, Inline: []PolicyRule{
+		{Name: "telekom", ASNs: []uint{3320}, Servers: []string{"198.51.100.4/32"}},
+		{Name: "north", Countries: []string{"CN"}, Servers: []string{"cn-bj"}},
+		{Name: "eu", Continents: []string{"EU"}, Servers: []string{"eu-*"}},
+		{Name: "us", Countries: []string{"US"}, Servers: []string{"us-*"}},
+		{Name: "cn-east", Countries: []string{"CN"}, Continents: []string{"AS"}, Servers: []string{"cn-*", "198.51.100.4"}},
+	}}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	all := []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4", "203.0.113.9"}
+	tests := []struct {
+		name     string
+		client   clientInfo
+		servers  []string
+		wantRule string
+		want     []string
+	}{
+		{name: "asn prefix", client: clientInfo{Country: "DE", Continent: "EU", ASN: 3320}, servers: all, wantRule: "telekom", want: []string{"198.51.100.4"}},
+		{name: "continent pattern", client: clientInfo{Country: "FR", Continent: "EU"}, servers: all, wantRule: "eu", want: []string{"198.51.100.4"}},
+		{name: "country datacenter", client: clientInfo{Country: "CN", Continent: "AS"}, servers: all, wantRule: "north", want: []string{"198.51.100.1"}},
+		{
+			name: "skip rule without servers", client: clientInfo{Country: "CN", Continent: "AS"},
+			servers:  []string{"198.51.100.2", "198.51.100.3", "198.51.100.4", "203.0.113.9"},
+			wantRule: "cn-east", want: []string{"198.51.100.2", "198.51.100.3", "198.51.100.4"},
+		},
+		{name: "no servers selected", client: clientInfo{Country: "US", Continent: "NA"}, servers: all},
+		{name: "no rule matched", client: clientInfo{Country: "JP", Continent: "AS"}, servers: all},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestGeoRoute(t)
+			rule, pool := p.match(tt.client, testCandidates(t, tt.servers...), s.locate)
+			if rule != tt.wantRule {
+				t.Errorf("rule = %q, want %q", rule, tt.wantRule)
+			}
+			if got := candidateIPs(pool); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pool = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyMatchLocate(t *testing.T) {
+	tests := []struct {
+		name        string
+		servers     []string
+		wantLocates int
+	}{
+		{name: "prefix only", servers: []string{"198.51.100.0/24"}, wantLocates: 0},
+		{name: "prefix before pattern", servers: []string{"198.51.100.1", "cn-*"}, wantLocates: 1},
+		{name: "pattern", servers: []string{"cn-*"}, wantLocates: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{Log: common.NewLogger("georoute"), Inline: []PolicyRule{{Servers: tt.servers}}}
+			if err := p.Start(); err != nil {
+				t.Fatal(err)
+			}
+			s := newTestGeoRoute(t)
+			locates := 0
+			locate := func(c *candidate) *GeoLocation {
+				locates++
+				return s.locate(c)
+			}
+			_, pool := p.match(clientInfo{}, testCandidates(t, "198.51.100.1", "198.51.100.2"), locate)
+			if len(pool) != 2 {
+				t.Errorf("pool = %v, want 2 servers", candidateIPs(pool))
+			}
+			if locates != tt.wantLocates {
+				t.Errorf("locate called %d times, want %d", locates, tt.wantLocates)
+			}
+		})
+	}
+}
+
+func TestPolicyValidate(t *testing.T) {
+	dir := t.TempDir()
+	withASN := filepath.Join(dir, "asn.json")
+	withoutASN := filepath.Join(dir, "country.json")
+	if err := os.WriteFile(withASN, []byte(`[{"asn":[3320],"servers":["eu-*"]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(withoutASN, []byte(`[{"country":["CN"],"servers":["cn-*"]}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	asnRule := []PolicyRule{{ASNs: []uint{3320}, Servers: []string{"eu-*"}}}
+	tests := []struct {
+		name    string
+		policy  *Policy
+		wantErr bool
+	}{
+		{name: "inline asn without db", policy: &Policy{Inline: asnRule}, wantErr: true},
+		{name: "inline asn with db", policy: &Policy{Inline: asnRule, ASN: true}},
+		{name: "file asn without db", policy: &Policy{FilePath: withASN}, wantErr: true},
+		{name: "file without asn", policy: &Policy{FilePath: withoutASN}},
+		{name: "missing file", policy: &Policy{FilePath: filepath.Join(dir, "missing.json")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
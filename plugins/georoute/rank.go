@@ -0,0 +1,120 @@
+package georoute
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// 就近选择方式
+const (
+	RankThreshold = "threshold" // 返回距离阈值内的服务器（默认）
+	RankNearest   = "nearest"   // 返回最近的 N 个服务器
+	RankWithin    = "within"    // 返回距离不超过最近服务器 (1+X%) 倍的服务器
+)
+
+// candidate 候选服务器记录
+type candidate struct {
+	rr       dns.RR
+	ip       netip.Addr
+	distance float64      // 与客户端的距离（公里），服务器位置未知时为 +Inf
+	location *GeoLocation // 服务器位置，未知时为 nil
+	located  bool         // 是否已查询过位置，见 locate
+}
+
+// parseRank 解析 rank 指令参数：
+//
+//	rank threshold
+//	rank nearest N
+//	rank within PCT[%] [MIN_KM]
+func (s *GeoRoute) parseRank(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing rank mode")
+	}
+	switch mode := args[0]; mode {
+	case RankThreshold:
+		if len(args) != 1 {
+			return fmt.Errorf("threshold takes no arguments")
+		}
+	case RankNearest:
+		if len(args) != 2 {
+			return fmt.Errorf("nearest requires N")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid nearest count: %s", args[1])
+		}
+		s.NearestN = n
+	case RankWithin:
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("within requires PCT [MIN_KM]")
+		}
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(args[1], "%"), 64)
+		if err != nil || pct < 0 {
+			return fmt.Errorf("invalid within percent: %s", args[1])
+		}
+		s.WithinPercent = pct
+		if len(args) == 3 {
+			km, err := strconv.ParseFloat(args[2], 64)
+			if err != nil || km < 0 {
+				return fmt.Errorf("invalid within minimum distance: %s", args[2])
+			}
+			s.WithinMinKm = km
+		}
+	default:
+		return fmt.Errorf("unknown rank mode: %s", mode)
+	}
+	s.Rank = args[0]
+	return nil
+}
+
+// rankCandidates 计算各服务器与客户端的距离并按距离升序排序；距离相同时按 IP 排序，保证结果确定
+func (s *GeoRoute) rankCandidates(cands []candidate, client *GeoLocation) {
+	for i := range cands {
+		cands[i].distance = math.Inf(1)
+		if loc := s.locate(&cands[i]); loc != nil {
+			cands[i].distance = calculateDistance(client.Latitude, client.Longitude, loc.Latitude, loc.Longitude)
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool {
+		if cands[i].distance != cands[j].distance {
+			return cands[i].distance < cands[j].distance
+		}
+		return cands[i].ip.Less(cands[j].ip)
+	})
+}
+
+// selectCandidates 从已排序的候选中选出返回的服务器，位置未知的服务器排在最后
+func (s *GeoRoute) selectCandidates(cands []candidate) []candidate {
+	if len(cands) == 0 {
+		return nil
+	}
+	switch s.Rank {
+	case RankNearest:
+		if len(cands) > s.NearestN {
+			return cands[:s.NearestN]
+		}
+		return cands
+	case RankWithin:
+		// 所有服务器位置都未知时 limit 为 +Inf，返回全部
+		limit := math.Max(cands[0].distance*(1+s.WithinPercent/100), s.WithinMinKm)
+		n := 0
+		for n < len(cands) && cands[n].distance <= limit {
+			n++
+		}
+		return cands[:n]
+	}
+	// threshold：阈值内的服务器，位置未知的服务器同样保留
+	var selected []candidate
+	for _, c := range cands {
+		if c.distance <= s.DistanceThreshold || math.IsInf(c.distance, 1) {
+			selected = append(selected, c)
+		}
+	}
+	return selected
+}
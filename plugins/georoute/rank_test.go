@@ -0,0 +1,152 @@
+package georoute
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/miekg/dns"
+)
+
+// testServers 测试用服务器位置：北京、上海、广州、法兰克福，203.0.113.9 位置未知
+var testServers = []ServerEntry{
+	{CIDR: "198.51.100.1", Latitude: 39.90, Longitude: 116.40, Country: "CN", Datacenter: "cn-bj"},
+	{CIDR: "198.51.100.2", Latitude: 31.23, Longitude: 121.47, Country: "CN", Datacenter: "cn-sh"},
+	{CIDR: "198.51.100.3", Latitude: 23.13, Longitude: 113.26, Country: "CN", Datacenter: "cn-gz"},
+	{CIDR: "198.51.100.4", Latitude: 50.11, Longitude: 8.68, Country: "DE", Datacenter: "eu-fra"},
+}
+
+// newTestGeoRoute 创建只使用服务器位置表定位的测试插件
+func newTestGeoRoute(t *testing.T) *GeoRoute {
+	t.Helper()
+	s := &GeoRoute{Log: common.NewLogger("georoute")}
+	s.Servers.Log = s.Log
+	s.Servers.Table = common.NewCIDRTable[*GeoLocation](0)
+	s.Servers.rebuild(testServers)
+	return s
+}
+
+// testCandidates 按顺序为地址构造候选服务器
+func testCandidates(t *testing.T, ips ...string) []candidate {
+	t.Helper()
+	var cands []candidate
+	for _, ip := range ips {
+		rr, err := dns.NewRR(fmt.Sprintf("www.example.org. 60 IN A %s", ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cands = append(cands, candidate{rr: rr, ip: netip.MustParseAddr(ip)})
+	}
+	return cands
+}
+
+// candidateIPs 候选服务器的地址
+func candidateIPs(cands []candidate) []string {
+	var ips []string
+	for _, c := range cands {
+		ips = append(ips, c.ip.String())
+	}
+	return ips
+}
+
+func TestRankCandidates(t *testing.T) {
+	s := newTestGeoRoute(t)
+	client := &GeoLocation{Latitude: 39.90, Longitude: 116.40} // 北京
+	cands := testCandidates(t, "203.0.113.9", "198.51.100.4", "198.51.100.3", "198.51.100.2", "198.51.100.1")
+	s.rankCandidates(cands, client)
+	want := []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4", "203.0.113.9"}
+	if got := candidateIPs(cands); !reflect.DeepEqual(got, want) {
+		t.Errorf("rank order = %v, want %v", got, want)
+	}
+	if cands[0].distance != 0 || !math.IsInf(cands[4].distance, 1) {
+		t.Errorf("distances = %v .. %v, want 0 .. +Inf", cands[0].distance, cands[4].distance)
+	}
+	for _, c := range cands {
+		if !c.located {
+			t.Errorf("candidate %s not marked located", c.ip)
+		}
+	}
+}
+
+func TestSelectCandidates(t *testing.T) {
+	all := []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4", "203.0.113.9"}
+	tests := []struct {
+		name string
+		rank []string // rank 指令参数，为空时为默认的 threshold
+		km   float64  // threshold 模式的距离阈值
+		want []string
+	}{
+		{name: "threshold keeps unknown", km: 1500, want: []string{"198.51.100.1", "198.51.100.2", "203.0.113.9"}},
+		{name: "threshold wide", km: 2500, want: []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "203.0.113.9"}},
+		{name: "nearest 2", rank: []string{"nearest", "2"}, want: []string{"198.51.100.1", "198.51.100.2"}},
+		{name: "nearest more than servers", rank: []string{"nearest", "10"}, want: all},
+		{name: "within only nearest", rank: []string{"within", "50%"}, want: []string{"198.51.100.1"}},
+		{name: "within min km", rank: []string{"within", "50", "2000"}, want: []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestGeoRoute(t)
+			s.Rank, s.DistanceThreshold = RankThreshold, tt.km
+			if len(tt.rank) > 0 {
+				if err := s.parseRank(tt.rank); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cands := testCandidates(t, all...)
+			s.rankCandidates(cands, &GeoLocation{Latitude: 39.90, Longitude: 116.40})
+			if got := candidateIPs(s.selectCandidates(cands)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selected = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCandidatesAllUnknown(t *testing.T) {
+	for _, rank := range [][]string{{"threshold"}, {"nearest", "1"}, {"within", "10%"}} {
+		t.Run(rank[0], func(t *testing.T) {
+			s := newTestGeoRoute(t)
+			s.DistanceThreshold = 100
+			if err := s.parseRank(rank); err != nil {
+				t.Fatal(err)
+			}
+			cands := testCandidates(t, "203.0.113.8", "203.0.113.9")
+			s.rankCandidates(cands, &GeoLocation{Latitude: 39.90, Longitude: 116.40})
+			want := 2
+			if rank[0] == RankNearest {
+				want = 1
+			}
+			if got := s.selectCandidates(cands); len(got) != want {
+				t.Errorf("selected %d servers, want %d", len(got), want)
+			}
+		})
+	}
+}
+
+func TestParseRank(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{args: []string{"threshold"}},
+		{args: []string{"threshold", "1"}, wantErr: true},
+		{args: []string{"nearest", "3"}},
+		{args: []string{"nearest", "0"}, wantErr: true},
+		{args: []string{"nearest"}, wantErr: true},
+		{args: []string{"within", "20%", "100"}},
+		{args: []string{"within", "-1"}, wantErr: true},
+		{args: []string{"closest"}, wantErr: true},
+		{args: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.args), func(t *testing.T) {
+			s := &GeoRoute{}
+			if err := s.parseRank(tt.args); (err != nil) != tt.wantErr {
+				t.Errorf("parseRank(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
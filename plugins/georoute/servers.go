@@ -0,0 +1,199 @@
+package georoute
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"coredns-plugins/plugins/common"
+)
+
+// ServerEntry 服务器位置配置项：网段或单个 IP 对应的坐标与标签，优先于 GeoIP 数据库
+type ServerEntry struct {
+	CIDR       string  `json:"cidr"`
+	Latitude   float64 `json:"latitude"`
+	Longitude  float64 `json:"longitude"`
+	Country    string  `json:"country,omitempty"`
+	Region     string  `json:"region,omitempty"`
+	Datacenter string  `json:"datacenter,omitempty"`
+}
+
+// ServerLocations 服务器位置表：GeoLite2 对云厂商与 anycast 网段定位常有偏差，由该表覆盖服务器 IP 的位置
+type ServerLocations struct {
+	ApiUrl    string                          // 服务器位置 API 地址
+	Fetch     common.FetchConfig              // API 刷新间隔、超时与失败退避
+	Fetcher   *common.Fetcher                 // API 定时拉取
+	Snapshot  string                          // 快照文件路径，为空时不持久化
+	FilePath  string                          // 本地服务器位置文件路径（JSON/YAML/CSV）
+	File      *common.FileWatcher             // 本地服务器位置文件监听
+	Inline    []ServerEntry                   // Corefile 内联服务器位置
+	CacheSize int                             // 查找缓存大小
+	Table     *common.CIDRTable[*GeoLocation] // 服务器位置查找表（Trie + LRU缓存）
+	Log       *common.Logger                  // 插件日志
+	Sources   common.Sources[ServerEntry]     // API、本地文件与内联服务器位置，同一网段以 Corefile 内联 > 本地文件 > API 为准
+}
+
+// parseServerLocation 解析内联服务器位置：server_location CIDR|IP LAT LON [country=X] [region=X] [datacenter=X]
+func parseServerLocation(args []string) (ServerEntry, error) {
+	if len(args) < 3 {
+		return ServerEntry{}, fmt.Errorf("requires CIDR|IP LAT LON")
+	}
+	entry := ServerEntry{CIDR: args[0]}
+	if _, err := common.ParsePrefix(args[0]); err != nil {
+		return entry, fmt.Errorf("invalid CIDR: %s", args[0])
+	}
+	var err error
+	if entry.Latitude, entry.Longitude, err = parseCoordinates(args[1], args[2]); err != nil {
+		return entry, err
+	}
+	for _, arg := range args[3:] {
+		key, value, ok := strings.Cut(arg, "=")
+		switch {
+		case !ok || value == "":
+			return entry, fmt.Errorf("invalid label: %s", arg)
+		case key == "country":
+			entry.Country = value
+		case key == "region":
+			entry.Region = value
+		case key == "datacenter":
+			entry.Datacenter = value
+		default:
+			return entry, fmt.Errorf("unknown label: %s", key)
+		}
+	}
+	return entry, nil
+}
+
+// parseCoordinates 解析并校验经纬度
+func parseCoordinates(lat, lon string) (float64, float64, error) {
+	latitude, err := strconv.ParseFloat(lat, 64)
+	if err != nil || latitude < -90 || latitude > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude: %s", lat)
+	}
+	longitude, err := strconv.ParseFloat(lon, 64)
+	if err != nil || longitude < -180 || longitude > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude: %s", lon)
+	}
+	return latitude, longitude, nil
+}
+
+// Enabled 是否配置了任一来源
+func (l *ServerLocations) Enabled() bool {
+	return l.ApiUrl != "" || l.FilePath != "" || len(l.Inline) > 0
+}
+
+// Start 加载内联服务器位置，并启动本地文件监听与 API 定时拉取
+func (l *ServerLocations) Start() {
+	l.Table = common.NewCIDRTable[*GeoLocation](l.CacheSize)
+	l.Sources.Name = "服务器位置"
+	l.Sources.Log = l.Log
+	l.Sources.Key = serverKey
+	l.Sources.Format = common.FormatOf(l.FilePath)
+	l.Sources.FromRecord = serverRecord
+	l.Sources.Build = l.rebuild
+	if len(l.Inline) > 0 {
+		l.Sources.Set(common.SourceInline, l.Inline)
+	}
+	if l.FilePath != "" {
+		l.File = &common.FileWatcher{
+			Log:    l.Log,
+			Path:   l.FilePath,
+			OnData: l.Sources.LoadFile,
+		}
+		l.File.Start()
+	}
+	if l.ApiUrl != "" {
+		l.Fetcher = &common.Fetcher{
+			FetchConfig: l.Fetch,
+			Log:         l.Log,
+			URL:         l.ApiUrl,
+			OnData:      l.Sources.LoadAPI,
+			OnDelta:     l.Sources.LoadDelta,
+			Observe:     observeServerFetch,
+			Snapshot:    l.Snapshot,
+		}
+		l.Fetcher.Start()
+	}
+}
+
+// Stop 停止本地文件监听与 API 拉取
+func (l *ServerLocations) Stop() error {
+	if l.File != nil {
+		l.File.Stop()
+	}
+	if l.Fetcher != nil {
+		l.Fetcher.Stop()
+	}
+	return nil
+}
+
+// Lookup 查询服务器 IP 的配置位置，未配置或未命中时返回 nil
+func (l *ServerLocations) Lookup(ip netip.Addr) *GeoLocation {
+	if l == nil || l.Table == nil {
+		return nil
+	}
+	entry, ok := l.Table.Lookup(ip)
+	if !ok {
+		return nil
+	}
+	return entry.Value
+}
+
+// serverRecord 解析 CSV 服务器位置文件的一行，需要 cidr、latitude、longitude 列，country、region、datacenter 列可选
+func serverRecord(record map[string]string) (ServerEntry, error) {
+	entry := ServerEntry{
+		CIDR:       record["cidr"],
+		Country:    record["country"],
+		Region:     record["region"],
+		Datacenter: record["datacenter"],
+	}
+	var err error
+	entry.Latitude, entry.Longitude, err = parseCoordinates(record["latitude"], record["longitude"])
+	return entry, err
+}
+
+// serverKey 服务器位置条目的主键，按掩码归一化，单个 IP 视为 /32 或 /128
+func serverKey(e ServerEntry) string {
+	if prefix, err := common.ParsePrefix(e.CIDR); err == nil {
+		return prefix.String()
+	}
+	return e.CIDR
+}
+
+// rebuild 用合并后的服务器位置重建查找表，网段或经纬度无效的条目被忽略
+func (l *ServerLocations) rebuild(merged []ServerEntry) int {
+	entries := make([]common.Entry[*GeoLocation], 0, len(merged))
+	for _, entry := range merged {
+		prefix, err := common.ParsePrefix(entry.CIDR)
+		if err != nil || entry.Latitude < -90 || entry.Latitude > 90 || entry.Longitude < -180 || entry.Longitude > 180 {
+			l.Log.Warningf("invalid server location ignored: %+v", entry)
+			continue
+		}
+		location := &GeoLocation{
+			Country:    entry.Country,
+			Region:     entry.Region,
+			Latitude:   entry.Latitude,
+			Longitude:  entry.Longitude,
+			Datacenter: entry.Datacenter,
+		}
+		entries = append(entries, common.Entry[*GeoLocation]{Prefix: prefix, Value: location})
+	}
+	count := l.Table.Replace(entries)
+	serverEntries.Set(float64(count))
+	return count
+}
+
+// Status 管理接口展示的服务器位置加载状态
+func (l *ServerLocations) Status() *serverStatus {
+	status := &serverStatus{
+		Entries: l.Table.Len(),
+		Sources: l.Sources.Counts(),
+		File:    l.FilePath,
+	}
+	if l.Fetcher != nil {
+		fetch := l.Fetcher.Status()
+		status.Fetch = &fetch
+	}
+	return status
+}
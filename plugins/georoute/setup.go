@@ -2,6 +2,9 @@ package georoute
 
 import (
 	"fmt"
+	"time"
+
+	"coredns-plugins/plugins/common"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
@@ -13,16 +16,34 @@ func init() { plugin.Register("georoute", setup) }
 
 func setup(c *caddy.Controller) error {
 	clog.Info("[georoute] setup called")
-	georoute := &GeoRoute{}
+	georoute := &GeoRoute{Log: common.NewLogger("georoute"), ReloadInterval: common.DefaultWatchInterval}
 
+	// 三个插件共用的指令
+	shared := []common.DirectiveParser{georoute.Log, &georoute.DNSSEC, &georoute.Answers, &georoute.TTL, &georoute.Servers.Fetch, &georoute.EcsTrusted, &georoute.Health}
 	for c.Next() {
 		for c.NextBlock() {
+			if ok, err := common.ParseDirectives(c, shared...); ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			switch c.Val() {
 			case "geoip_db":
 				if !c.NextArg() {
 					return c.ArgErr()
 				}
 				georoute.GeoIPDBPath = c.Val()
+			case "reload":
+				// reload DURATION：数据库文件检查间隔，0 表示不热加载
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				d, err := time.ParseDuration(c.Val())
+				if err != nil || d < 0 {
+					return c.Errf("invalid reload value: %s", c.Val())
+				}
+				georoute.ReloadInterval = d
 			case "cache_size":
 				if !c.NextArg() {
 					return c.ArgErr()
@@ -43,11 +64,85 @@ func setup(c *caddy.Controller) error {
 					return c.Errf("invalid distance_threshold value: %s", c.Val())
 				}
 				georoute.DistanceThreshold = threshold
+			case "rank":
+				if err := georoute.parseRank(c.RemainingArgs()); err != nil {
+					return c.Errf("invalid rank: %v", err)
+				}
+			case "server_api":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				georoute.Servers.ApiUrl = c.Val()
+			case "server_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				georoute.Servers.FilePath = c.Val()
+			case "snapshot_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				georoute.Servers.Snapshot = c.Val()
+			case "server_location":
+				entry, err := parseServerLocation(c.RemainingArgs())
+				if err != nil {
+					return c.Errf("invalid server_location: %v", err)
+				}
+				georoute.Servers.Inline = append(georoute.Servers.Inline, entry)
+			case "asn_db":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				georoute.ASNDBPath = c.Val()
+			case "policy":
+				rule, err := parsePolicyRule(c.RemainingArgs())
+				if err == nil {
+					_, err = rule.compile("corefile")
+				}
+				if err != nil {
+					return c.Errf("invalid policy: %v", err)
+				}
+				georoute.Policy.Inline = append(georoute.Policy.Inline, rule)
+			case "policy_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				georoute.Policy.FilePath = c.Val()
+			case "admin":
+				addr, err := common.AdminDirective(c)
+				if err != nil {
+					return err
+				}
+				georoute.AdminAddr = addr
 			}
 		}
 	}
 
+	if err := georoute.Servers.Fetch.Validate(); err != nil {
+		return c.Err(err.Error())
+	}
+	// 本插件的记录没有权重，不接受 weighted_* 选择方式
+	if err := georoute.Answers.Validate(false); err != nil {
+		return c.Err(err.Error())
+	}
+	if err := georoute.TTL.Validate("internal", "policy", "geo", "unknown", "fallback"); err != nil {
+		return c.Err(err.Error())
+	}
+	georoute.Policy.ASN = georoute.ASNDBPath != ""
+	if err := georoute.Policy.Validate(); err != nil {
+		return c.Err(err.Error())
+	}
+
+	if georoute.Health.Enabled() {
+		georoute.Health.Log = georoute.Log
+		georoute.Health.Start()
+		c.OnShutdown(georoute.Health.Stop)
+	}
 	georoute.InitGeoRoute()
+	c.OnShutdown(georoute.Stop)
+	if georoute.AdminAddr != "" {
+		common.RegisterAdmin(c, georoute.AdminAddr, georoute)
+	}
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		georoute.Next = next
 		return georoute
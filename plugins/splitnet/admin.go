@@ -0,0 +1,80 @@
+package splitnet
+
+import (
+	"net/netip"
+
+	"coredns-plugins/plugins/common"
+)
+
+// splitStatus 管理接口展示的加载状态
+type splitStatus struct {
+	TableVersion uint64                `json:"table_version"`
+	CIDRs        int                   `json:"cidrs"`
+	Sources      map[string]int        `json:"sources"`
+	Fetch        *common.FetchStatus   `json:"fetch,omitempty"`
+	File         string                `json:"file,omitempty"`
+	Mode         string                `json:"mode"`
+	ModeZones    map[string]string     `json:"mode_zones,omitempty"`
+	Views        map[string]viewStatus `json:"views,omitempty"`
+	Internal     []CIDREntry           `json:"internal_cidrs"`
+}
+
+// splitLookup 管理接口的地址查询结果
+type splitLookup struct {
+	Internal bool   `json:"internal"`
+	View     string `json:"view"`
+	Prefix   string `json:"prefix,omitempty"`
+	Desc     string `json:"desc,omitempty"`
+}
+
+// viewStatus 管理接口展示的视图配置
+type viewStatus struct {
+	Internal  bool     `json:"internal,omitempty"`
+	Answers   int      `json:"answers,omitempty"`
+	Hosts     string   `json:"hosts,omitempty"`
+	HostNames int      `json:"host_names,omitempty"`
+	Upstreams []string `json:"upstreams,omitempty"`
+}
+
+// Status 合并后的内网网段、查找表版本与各来源条目数
+func (s *SplitNet) Status() interface{} {
+	status := splitStatus{
+		TableVersion: s.Table.Version(),
+		CIDRs:        s.Table.Len(),
+		Sources:      s.Sources.Counts(),
+		File:         s.FilePath,
+		Mode:         s.Mode,
+		ModeZones:    s.ModeZones,
+		Internal:     s.Sources.Merged(),
+	}
+	for name, view := range s.Views {
+		if status.Views == nil {
+			status.Views = make(map[string]viewStatus, len(s.Views))
+		}
+		vs := viewStatus{Internal: view.Internal, Answers: len(view.Answers), Hosts: view.HostsPath, Upstreams: view.Upstreams}
+		if hosts := view.hosts.Load(); hosts != nil {
+			vs.HostNames = len(*hosts)
+		}
+		status.Views[name] = vs
+	}
+	if s.Fetcher != nil {
+		fetch := s.Fetcher.Status()
+		status.Fetch = &fetch
+	}
+	return status
+}
+
+// Lookup 返回匹配的网段、客户端所属视图，以及该客户端是否按内网客户端过滤
+func (s *SplitNet) Lookup(ip netip.Addr) interface{} {
+	entry, ok := s.Table.Lookup(ip)
+	if !ok {
+		return splitLookup{View: ViewPublic}
+	}
+	view := s.viewOf(ip)
+	return splitLookup{Internal: s.isInternalClient(view), View: view, Prefix: entry.Prefix.String(), Desc: entry.Value.Desc}
+}
+
+// CacheStats 内网网段查找缓存统计
+func (s *SplitNet) CacheStats() interface{} {
+	return s.Table.Stats()
+}
@@ -0,0 +1,121 @@
+package splitnet
+
+import (
+	"errors"
+	"time"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// requestCount 按客户端内外网类型统计的请求数
+	requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "requests_total",
+		Help:      "Counter of requests filtered by splitnet, by client type (internal or external).",
+	}, []string{"server", "client"})
+	// fallbackCount 没有匹配类型的记录、回退返回全部记录的次数
+	fallbackCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "fallback_total",
+		Help:      "Counter of requests that fell back to returning all records.",
+	}, []string{"server"})
+	// nodataCount strict 模式下外网客户端没有外网记录、返回 NODATA 的次数
+	nodataCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "nodata_total",
+		Help:      "Counter of strict mode responses with all internal records removed for external clients.",
+	}, []string{"server"})
+	// viewCount 由视图配置直接应答的请求数，backend 为 answer/hosts/forward
+	viewCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "view_requests_total",
+		Help:      "Counter of requests answered by a view, by view name and backend.",
+	}, []string{"server", "view", "backend"})
+	// cacheHits IpCache 命中次数
+	cacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "cache_hits_total",
+		Help:      "Counter of internal IP lookups served from the LRU cache.",
+	})
+	// cacheMisses IpCache 未命中次数
+	cacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "cache_misses_total",
+		Help:      "Counter of internal IP lookups that missed the LRU cache.",
+	})
+	// fetchCount 内网网段 API 拉取次数，按结果区分
+	fetchCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "fetch_total",
+		Help:      "Counter of internal CIDR API fetches, by result.",
+	}, []string{"result"})
+	// fetchDuration 内网网段 API 拉取耗时
+	fetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "fetch_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time taken to fetch and load the internal CIDR API.",
+	})
+	// cidrEntries 当前加载的内网网段数
+	cidrEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "cidrs",
+		Help:      "The number of internal CIDRs currently loaded.",
+	})
+	// reloadTime 最近一次成功热加载的时间戳
+	reloadTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "reload_timestamp_seconds",
+		Help:      "The timestamp of the last successful internal CIDR reload.",
+	})
+	// snapshotTime 快照文件对应的拉取时间
+	snapshotTime = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "splitnet",
+		Name:      "snapshot_timestamp_seconds",
+		Help:      "The modification time of the internal CIDR snapshot file last loaded or written.",
+	})
+)
+
+// observeLookup 统计 LRU 缓存命中情况
+func observeLookup(hit bool) {
+	if hit {
+		cacheHits.Inc()
+	} else {
+		cacheMisses.Inc()
+	}
+}
+
+// observeFetch 统计 API 拉取结果与耗时
+func observeFetch(err error, duration time.Duration) {
+	fetchDuration.Observe(duration.Seconds())
+	switch {
+	case err == nil:
+		fetchCount.WithLabelValues("success").Inc()
+	case errors.Is(err, common.ErrNotModified):
+		fetchCount.WithLabelValues("unchanged").Inc()
+	default:
+		fetchCount.WithLabelValues("failure").Inc()
+	}
+}
+
+// observeSnapshot 记录快照时间，快照年龄为当前时间与该值之差
+func observeSnapshot(modTime time.Time) {
+	snapshotTime.Set(float64(modTime.Unix()))
+}
@@ -1,38 +1,41 @@
 package splitnet
 
 import (
+	"net/netip"
 	"strconv"
-	"time"
+	"strings"
+
+	"coredns-plugins/plugins/common"
 
 	"github.com/coredns/caddy"
 	"github.com/coredns/coredns/core/dnsserver"
 	"github.com/coredns/coredns/plugin"
 	clog "github.com/coredns/coredns/plugin/pkg/log"
+	"github.com/miekg/dns"
 )
 
 func init() { plugin.Register("splitnet", setup) }
 
 func setup(c *caddy.Controller) error {
 	clog.Info("[splitnet] setup called")
-	splitnet := &SplitNet{}
+	splitnet := &SplitNet{Log: common.NewLogger("splitnet")}
 
+	// 三个插件共用的指令
+	shared := []common.DirectiveParser{splitnet.Log, &splitnet.DNSSEC, &splitnet.Answers, &splitnet.TTL, &splitnet.Fetch, &splitnet.EcsTrusted, &splitnet.Health}
 	for c.Next() {
 		for c.NextBlock() {
+			if ok, err := common.ParseDirectives(c, shared...); ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
 			switch c.Val() {
-			case "cidr_api":
+			case "cidr_api", "api_url":
 				if !c.NextArg() {
 					return c.ArgErr()
 				}
 				splitnet.ApiUrl = c.Val()
-			case "refresh_interval":
-				if !c.NextArg() {
-					return c.ArgErr()
-				}
-				duration, err := time.ParseDuration(c.Val())
-				if err != nil {
-					return c.Errf("invalid refresh_interval value: %s", c.Val())
-				}
-				splitnet.ApiInterval = duration
 			case "cache_size":
 				if !c.NextArg() {
 					return c.ArgErr()
@@ -42,16 +45,109 @@ func setup(c *caddy.Controller) error {
 					return c.Errf("invalid cache_size value: %s", c.Val())
 				}
 				splitnet.CacheSize = size
+			case "snapshot_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				splitnet.Snapshot = c.Val()
+			case "cidr_file":
+				if !c.NextArg() {
+					return c.ArgErr()
+				}
+				splitnet.FilePath = c.Val()
+			case "mode":
+				// mode MODE [ZONE...]：不带域名时为默认模式，带域名时作用于这些域名及其子域名
+				args := c.RemainingArgs()
+				if len(args) == 0 {
+					return c.ArgErr()
+				}
+				mode := args[0]
+				if mode != ModePrefer && mode != ModeStrict {
+					return c.Errf("invalid mode value: %s", mode)
+				}
+				if len(args) == 1 {
+					splitnet.Mode = mode
+					continue
+				}
+				if splitnet.ModeZones == nil {
+					splitnet.ModeZones = make(map[string]string)
+				}
+				for _, zone := range args[1:] {
+					if _, ok := dns.IsDomainName(zone); !ok {
+						return c.Errf("invalid mode zone: %s", zone)
+					}
+					splitnet.ModeZones[dns.CanonicalName(zone)] = mode
+				}
+			case "view":
+				// view NAME answer|hosts|forward|internal ARGS...
+				args := c.RemainingArgs()
+				if len(args) < 2 {
+					return c.ArgErr()
+				}
+				if splitnet.Views == nil {
+					splitnet.Views = make(map[string]*View)
+				}
+				view := splitnet.Views[args[0]]
+				if view == nil {
+					view = &View{Name: args[0]}
+					splitnet.Views[args[0]] = view
+				}
+				if err := view.parseDirective(args[1], args[2:]); err != nil {
+					return c.Errf("invalid view %s: %v", args[0], err)
+				}
+			case "admin":
+				addr, err := common.AdminDirective(c)
+				if err != nil {
+					return err
+				}
+				splitnet.AdminAddr = addr
+			default:
+				// 以网段开头的行为内联网段：CIDR [view=VIEW] [DESC...]，其他未知指令报错
+				if _, err := netip.ParsePrefix(c.Val()); err != nil {
+					return c.Errf("unknown property '%s'", c.Val())
+				}
+				entry := CIDREntry{CIDR: c.Val()}
+				args := c.RemainingArgs()
+				if len(args) > 0 && strings.HasPrefix(args[0], "view=") {
+					entry.View, args = strings.TrimPrefix(args[0], "view="), args[1:]
+				}
+				entry.Desc = strings.Join(args, " ")
+				splitnet.Inline = append(splitnet.Inline, entry)
 			}
 		}
 	}
 
-	// 设置默认值
-	if splitnet.ApiInterval == 0 {
-		splitnet.ApiInterval = 60 * time.Second
+	if splitnet.ApiUrl == "" && splitnet.FilePath == "" && len(splitnet.Inline) == 0 {
+		return c.Err("cidr_api, cidr_file or inline CIDRs required")
+	}
+	if err := splitnet.Fetch.Validate(); err != nil {
+		return c.Err(err.Error())
+	}
+	// 本插件的记录没有权重，不接受 weighted_* 选择方式
+	if err := splitnet.Answers.Validate(false); err != nil {
+		return c.Err(err.Error())
+	}
+	if err := splitnet.TTL.Validate("internal", "external", "fallback", "nodata", "view"); err != nil {
+		return c.Err(err.Error())
 	}
 
+	if splitnet.Mode == "" {
+		splitnet.Mode = ModePrefer
+	}
+	for zone := range splitnet.ModeZones {
+		splitnet.zones = append(splitnet.zones, zone)
+	}
+
+	if splitnet.Health.Enabled() {
+		splitnet.Health.Log = splitnet.Log
+		splitnet.Health.Start()
+		c.OnShutdown(splitnet.Health.Stop)
+	}
 	splitnet.InitAndUpdateCIDR()
+	c.OnShutdown(splitnet.Stop)
+	if splitnet.AdminAddr != "" {
+		common.RegisterAdmin(c, splitnet.AdminAddr, splitnet)
+	}
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		splitnet.Next = next
 		return splitnet
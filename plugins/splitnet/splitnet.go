@@ -2,276 +2,498 @@ package splitnet
 
 import (
 	"context"
-	"encoding/json"
-	"io"
-	"log"
-	"net"
-	"net/http"
-	"strings"
-	"sync"
+	"net/netip"
 	"time"
 
+	"coredns-plugins/plugins/common"
+
 	"github.com/coredns/coredns/plugin"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
 	"github.com/miekg/dns"
-	"github.com/yl2chen/cidranger"
 )
 
-// CIDREntry 内网网段配置项
+// CIDREntry 内网网段配置项，View 为客户端所属视图，为空时属于 internal 视图
 type CIDREntry struct {
 	CIDR string `json:"cidr"`
 	Desc string `json:"desc,omitempty"`
+	View string `json:"view,omitempty"`
 }
 
-// SplitNet 内外网区分解析插件
-type SplitNet struct {
-	Next         plugin.Handler
-	ApiUrl       string           // 内网网段API地址
-	InternalCIDR []*net.IPNet     // 内网网段列表
-	ApiLock      sync.RWMutex     // 读写锁
-	ApiInterval  time.Duration    // API刷新间隔
-	Ranger       cidranger.Ranger // 高效网段查找结构
-	IpCache      *lru.Cache       // IP归属缓存
-	CacheSize    int              // 缓存大小
+// cidrInfo 网段查找表中保存的值
+type cidrInfo struct {
+	Desc string
+	View string
 }
 
-// responseCaptureWriter 捕获下游插件响应
-type responseCaptureWriter struct {
-	dns.ResponseWriter
-	Msg *dns.Msg
-}
+// nodataTTL 合成 SOA 的 TTL 与否定缓存时间（秒），未配置 ttl nodata 时使用
+const nodataTTL = 60
+
+// 过滤模式
+const (
+	// ModePrefer 优先返回与客户端同类型的记录，没有时返回全部记录
+	ModePrefer = "prefer"
+	// ModeStrict 外网客户端不返回内网记录（内网网段及 RFC1918 等私有地址），没有外网记录时返回 NODATA
+	ModeStrict = "strict"
+)
 
-func (r *responseCaptureWriter) WriteMsg(res *dns.Msg) error {
-	r.Msg = res
-	return nil // 不直接写出，由 splitnet 处理
+// SplitNet 内外网区分解析插件
+type SplitNet struct {
+	Next       plugin.Handler
+	ApiUrl     string                      // 内网网段API地址
+	Table      *common.CIDRTable[cidrInfo] // 内网网段查找表（Trie + LRU缓存），值为网段描述与视图
+	CacheSize  int                         // 缓存大小
+	Fetcher    *common.Fetcher             // API 定时拉取
+	Fetch      common.FetchConfig          // API 刷新间隔、超时与失败退避
+	Snapshot   string                      // 快照文件路径，为空时不持久化
+	FilePath   string                      // 本地网段文件路径（JSON/YAML/CSV）
+	File       *common.FileWatcher         // 本地网段文件监听
+	Inline     []CIDREntry                 // Corefile 内联网段
+	Sources    common.Sources[CIDREntry]   // API、本地文件与内联网段，同一网段以 Corefile 内联 > 本地文件 > API 为准
+	Mode       string                      // 默认过滤模式
+	ModeZones  map[string]string           // 按域名覆盖的过滤模式，键为 FQDN，作用于该域名及其子域名
+	zones      plugin.Zones                // ModeZones 的键，用于最长匹配
+	Views      map[string]*View            // 按视图名称配置的解析方式
+	Answers    common.AnswerConfig         // 返回记录的排列方式与数量上限
+	TTL        common.TTLPolicy            // 按决策类型限制返回记录的 TTL
+	DNSSEC     common.DNSSEC               // 下游返回已签名记录时的处理方式
+	EcsTrusted common.ECSTrusted           // 允许携带 ECS 的递归解析器网段
+	Health     common.HealthChecker        // 后端健康检查，未配置规则时不过滤
+	Log        *common.Logger              // 插件日志
+	AdminAddr  string                      // 管理接口监听地址，为空时不启用
 }
 
 // ServeDNS 处理DNS请求
 func (s *SplitNet) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
-	// 捕获下游插件的响应
-	rw := &responseCaptureWriter{ResponseWriter: w}
+	clientIP, ecs := common.ClientSubnet(w, r, s.EcsTrusted)
+	clientView := s.viewOf(clientIP)
+	isInternal := s.isInternalClient(clientView)
+	// 客户端所属视图配置了解析方式时由视图应答，未命中再交给下游插件
+	if len(s.Views) > 0 && len(r.Question) > 0 {
+		if view := s.Views[clientView]; view != nil {
+			m, backend, err := view.resolve(ctx, r)
+			if err != nil {
+				s.Log.Warningf("%v", err)
+				return dns.RcodeServerFailure, err
+			}
+			if m != nil {
+				return s.writeView(ctx, w, r, view.Name, backend, clientIP, ecs, isInternal, m)
+			}
+		}
+	}
+
+	// 捕获下游插件的响应，在该响应上原地筛选
+	rw := common.NewResponseCaptureWriter(w)
 	code, err := plugin.NextOrFailure(s.Name(), s.Next, ctx, rw, r)
-	if err != nil || rw.Msg == nil || len(rw.Msg.Answer) == 0 {
+	if err != nil || rw.Msg == nil {
 		return code, err
 	}
 
-	// 仅有一个地址时直接返回
-	if len(rw.Msg.Answer) == 1 {
+	// 只筛选 CNAME 链末端的 A/AAAA 记录；没有地址、仅有一个地址或签名记录不能过滤时直接返回，
+	// strict 模式下仅有一个地址仍需检查，避免内网地址返回给外网客户端；签名应答含有不能返回给
+	// 外网客户端的记录时同样过滤，按 DNSSEC 配置重新签名或删除失效的签名。
+	// strict 模式下外网客户端的应答先去除链末端以外、权威段与附加段中的内网地址（如 MX/SRV/NS 的胶水记录）
+	mode := s.modeFor(r)
+	addrs, others := common.AddrRRs(rw.Msg)
+	if mode == ModeStrict && !isInternal {
+		others = s.stripInternal(rw.Msg, addrs, others)
+	}
+	if len(addrs) == 0 || (len(addrs) == 1 && mode != ModeStrict) ||
+		(s.DNSSEC.Skip(r, addrs, others) && !s.strictFilters(addrs, mode, isInternal)) {
+		// 原样返回全部记录，按 fallback 层级限制 TTL；只调低 TTL，原签名仍然有效
+		common.CapTTL(rw.Msg, addrs, s.TTL.Cap("fallback", s.Fetcher.Degraded()))
 		w.WriteMsg(rw.Msg)
-		return dns.RcodeSuccess, nil
+		return code, err
 	}
 
-	clientIP := getClientIP(w.RemoteAddr().String())
-	isInternal := s.isInternalIP(clientIP)
-	log.Printf("[splitnet] clientIP=%s, isInternal=%v", clientIP, isInternal)
+	verbose := s.Log.Sampled()
 
 	// 分类所有IP地址
-	var allIPs []string
-	var internalIPs []string
-	var externalIPs []string
+	var addrAnswers []dns.RR
 	var internalAnswers []dns.RR
 	var externalAnswers []dns.RR
-	var otherAnswers []dns.RR
-
-	for _, rr := range rw.Msg.Answer {
-		switch v := rr.(type) {
-		case *dns.A:
-			allIPs = append(allIPs, v.A.String())
-			if s.isInternalIP(v.A.String()) {
-				internalIPs = append(internalIPs, v.A.String())
-				internalAnswers = append(internalAnswers, rr)
-			} else {
-				externalIPs = append(externalIPs, v.A.String())
-				externalAnswers = append(externalAnswers, rr)
-			}
-		case *dns.AAAA:
-			allIPs = append(allIPs, v.AAAA.String())
-			if s.isInternalIP(v.AAAA.String()) {
-				internalIPs = append(internalIPs, v.AAAA.String())
-				internalAnswers = append(internalAnswers, rr)
-			} else {
-				externalIPs = append(externalIPs, v.AAAA.String())
-				externalAnswers = append(externalAnswers, rr)
-			}
-		default:
-			// 其他类型直接透传
-			otherAnswers = append(otherAnswers, rr)
+
+	// 剔除不健康的后端
+	for _, rr := range s.Health.Filter(addrs) {
+		ip, _ := common.RRAddr(rr)
+		addrAnswers = append(addrAnswers, rr)
+		if s.isInternalIP(ip) || (mode == ModeStrict && isPrivateAddr(ip)) {
+			internalAnswers = append(internalAnswers, rr)
+		} else {
+			externalAnswers = append(externalAnswers, rr)
 		}
 	}
 
-	log.Printf("[splitnet] hosts returned IPs: %v (internal: %v, external: %v)", allIPs, internalIPs, externalIPs)
-
-	// 智能选择返回策略
-	var filteredAnswers []dns.RR
-
+	// 智能选择返回策略：内网客户端优先返回内网IP，外网客户端优先返回外网IP，没有匹配类型时返回所有IP；
+	// strict 模式下外网客户端没有外网IP时返回 NODATA
+	clientType, preferred := "external", externalAnswers
 	if isInternal {
-		// 内网客户端：优先返回内网IP，如果没有内网IP则返回所有IP
-		if len(internalAnswers) > 0 {
-			filteredAnswers = append(filteredAnswers, internalAnswers...)
-			log.Printf("[splitnet] 内网客户端，返回内网IP: %v", internalIPs)
-		} else {
-			filteredAnswers = append(filteredAnswers, rw.Msg.Answer...)
-			log.Printf("[splitnet] 内网客户端，无内网IP，返回所有IP: %v", allIPs)
-		}
-	} else {
-		// 外网客户端：优先返回外网IP，如果没有外网IP则返回所有IP
-		if len(externalAnswers) > 0 {
-			filteredAnswers = append(filteredAnswers, externalAnswers...)
-			log.Printf("[splitnet] 外网客户端，返回外网IP: %v", externalIPs)
+		clientType, preferred = "internal", internalAnswers
+	}
+	tier := clientType
+	filteredAnswers := preferred
+	if len(preferred) == 0 {
+		if mode == ModeStrict && !isInternal {
+			tier = "nodata"
 		} else {
-			filteredAnswers = append(filteredAnswers, rw.Msg.Answer...)
-			log.Printf("[splitnet] 外网客户端，无外网IP，返回所有IP: %v", allIPs)
+			tier, filteredAnswers = "fallback", addrAnswers
 		}
 	}
 
-	// 添加其他类型的记录（如CNAME等）
-	filteredAnswers = append(filteredAnswers, otherAnswers...)
+	filteredAnswers = s.Answers.Select(s.Answers.Key(r, s.Answers.ClientKey(clientIP, ecs)), filteredAnswers, nil)
 
-	var retIPs []string
-	for _, rr := range filteredAnswers {
-		switch v := rr.(type) {
-		case *dns.A:
-			retIPs = append(retIPs, v.A.String())
-		case *dns.AAAA:
-			retIPs = append(retIPs, v.AAAA.String())
+	server := metrics.WithServer(ctx)
+	requestCount.WithLabelValues(server, clientType).Inc()
+	switch tier {
+	case "fallback":
+		fallbackCount.WithLabelValues(server).Inc()
+	case "nodata":
+		nodataCount.WithLabelValues(server).Inc()
+	}
+	// API 拉取失败时内网网段可能已过时，按降级上限缩短 TTL 以便尽快重新解析
+	degraded := s.Fetcher.Degraded()
+	ttl := s.TTL.Cap(tier, degraded)
+	// 模拟解析（管理接口 dryrun）时无论是否采样都记录决策
+	if trace := common.TraceFrom(ctx); verbose || trace != nil {
+		d := common.NewDecision(clientIP, ecs != nil, r, tier, filteredAnswers)
+		d.Attrs = map[string]string{"mode": mode}
+		d.SetTTL(ttl, degraded)
+		if verbose {
+			s.Log.Debugf("clientIP=%s, view=%s, isInternal=%v, hosts returned IPs: %v (internal: %v, external: %v), final returned IPs: %v",
+				clientIP, clientView, isInternal, common.Addrs(addrAnswers), common.Addrs(internalAnswers), common.Addrs(externalAnswers), common.Addrs(filteredAnswers))
+			s.Log.Decision(d)
 		}
+		trace.Add(s.Name(), d)
 	}
-	log.Printf("[splitnet] final returned IPs: %v", retIPs)
 
-	m := new(dns.Msg)
-	m.SetReply(r)
-	m.Answer = filteredAnswers
+	// CNAME 链等其余记录保留在前；nodata 时只剩 CNAME 链，权威段改为 SOA 便于解析器做否定缓存
+	m := rw.Msg
+	if ecs != nil {
+		common.SetECSScope(m, r, m, ecs, s.ecsScope(clientIP, ecs))
+	}
+	fitted, signErr := common.SetFiltered(w, r, m, &s.DNSSEC, others, addrs, filteredAnswers)
+	if signErr != nil {
+		s.Log.Warningf("%v", signErr)
+	}
+	if tier == "nodata" {
+		s.setNodata(r, m, ttl)
+	}
+	common.CapTTL(m, fitted, ttl)
+	if verbose && len(fitted) < len(filteredAnswers) {
+		s.Log.Debugf("answers trimmed from %d to %d to fit UDP size", len(filteredAnswers), len(fitted))
+	}
 	w.WriteMsg(m)
-	return dns.RcodeSuccess, nil
+	return code, err
 }
 
-// getClientIP 提取客户端IP
-func getClientIP(addr string) string {
-	if strings.Contains(addr, "[") { // IPv6
-		addr = strings.Split(addr, "]:")[0]
-		addr = strings.TrimPrefix(addr, "[")
-	} else {
-		addr = strings.Split(addr, ":")[0]
+// ecsScope 计算 ECS 响应的 SCOPE PREFIX-LENGTH：命中网段时取该网段掩码长度，否则沿用 SOURCE 长度；
+// rendezvous 模式下作用范围不小于客户端标识的前缀长度
+func (s *SplitNet) ecsScope(ip netip.Addr, ecs *dns.EDNS0_SUBNET) uint8 {
+	scope := ecs.SourceNetmask
+	if entry, ok := s.Table.Lookup(ip); ok {
+		scope = uint8(entry.Prefix.Bits())
 	}
-	return addr
+	return s.Answers.Scope(ip, ecs, scope)
 }
 
-// isInternalIP 判断是否为内网IP
-func (s *SplitNet) isInternalIP(ip string) bool {
-	// 先查缓存
-	if s.IpCache != nil {
-		if v, ok := s.IpCache.Get(ip); ok {
-			return v.(bool)
-		}
+// viewOf 客户端所属视图：命中网段时取网段的视图（未指定为 internal），否则为 public
+func (s *SplitNet) viewOf(ip netip.Addr) string {
+	entry, ok := s.Table.Lookup(ip)
+	switch {
+	case !ok:
+		return ViewPublic
+	case entry.Value.View == "":
+		return ViewInternal
 	}
+	return entry.Value.View
+}
 
-	s.ApiLock.RLock()
-	defer s.ApiLock.RUnlock()
+// writeView 写出视图应答：视图应答不按内外网优选，但 strict 模式下外网客户端（非 internal 视图）仍去除内网记录，
+// 没有剩余地址时返回 NODATA
+func (s *SplitNet) writeView(ctx context.Context, w dns.ResponseWriter, r *dns.Msg, view, backend string,
+	clientIP netip.Addr, ecs *dns.EDNS0_SUBNET, isInternal bool, m *dns.Msg) (int, error) {
+	server := metrics.WithServer(ctx)
+	viewCount.WithLabelValues(server, view, backend).Inc()
+	m.Id = r.Id
+	if ecs != nil {
+		common.SetECSScope(m, r, m, ecs, s.ecsScope(clientIP, ecs))
+	}
+	mode := s.modeFor(r)
+	addrs, others := common.AddrRRs(m)
+	if mode == ModeStrict && !isInternal {
+		others = s.stripInternal(m, addrs, others)
+	}
+	verbose := s.Log.Sampled()
+	degraded := s.Fetcher.Degraded()
+	ttl := s.TTL.Cap("view", degraded)
+	if s.strictFilters(addrs, mode, isInternal) {
+		var external []dns.RR
+		for _, rr := range addrs {
+			if ip, ok := common.RRAddr(rr); ok && !s.hiddenFromExternal(ip) {
+				external = append(external, rr)
+			}
+		}
+		fitted, signErr := common.SetFiltered(w, r, m, &s.DNSSEC, others, addrs, external)
+		if signErr != nil {
+			s.Log.Warningf("%v", signErr)
+		}
+		if len(external) == 0 {
+			nodataCount.WithLabelValues(server).Inc()
+			s.setNodata(r, m, ttl)
+		}
+		addrs = fitted
+	}
 
-	if s.Ranger == nil {
-		return false
+	if trace := common.TraceFrom(ctx); verbose || trace != nil {
+		d := common.NewDecision(clientIP, ecs != nil, r, "view", addrs)
+		d.Attrs = map[string]string{"view": view, "backend": backend, "mode": mode}
+		d.SetTTL(ttl, degraded)
+		if verbose {
+			s.Log.Debugf("clientIP=%s, view=%s, answered by %s: %v", clientIP, view, backend, common.Addrs(addrs))
+			s.Log.Decision(d)
+		}
+		trace.Add(s.Name(), d)
 	}
+	common.CapTTL(m, addrs, ttl)
+	w.WriteMsg(m)
+	return dns.RcodeSuccess, nil
+}
 
-	ipAddr := net.ParseIP(ip)
-	entries, err := s.Ranger.ContainingNetworks(ipAddr)
-	if err != nil || len(entries) == 0 {
-		// 缓存结果
-		if s.IpCache != nil {
-			s.IpCache.Add(ip, false)
+// modeFor 取查询名适用的过滤模式：按域名覆盖取最长匹配，没有匹配时为默认模式
+func (s *SplitNet) modeFor(r *dns.Msg) string {
+	if len(s.zones) > 0 && len(r.Question) > 0 {
+		if zone := s.zones.Matches(r.Question[0].Name); zone != "" {
+			return s.ModeZones[zone]
 		}
+	}
+	return s.Mode
+}
+
+// isPrivateAddr 是否为 RFC1918/RFC4193 私有地址、环回地址或链路本地地址
+func isPrivateAddr(ip netip.Addr) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()
+}
+
+// isInternalClient 客户端是否按内网客户端过滤：属于 internal 视图、标记为 internal 的视图，
+// 或 Corefile 中没有配置的视图（网段数据引用了视图但没有对应的 view 配置时，按未指定视图的内网网段处理）
+func (s *SplitNet) isInternalClient(view string) bool {
+	switch view {
+	case ViewInternal:
+		return true
+	case ViewPublic:
 		return false
 	}
+	v, ok := s.Views[view]
+	return !ok || v.Internal
+}
 
-	// 缓存结果
-	if s.IpCache != nil {
-		s.IpCache.Add(ip, true)
+// strictFilters strict 模式下外网客户端的应答中是否含有内网记录（必须过滤掉）
+func (s *SplitNet) strictFilters(addrs []dns.RR, mode string, isInternal bool) bool {
+	if mode != ModeStrict || isInternal {
+		return false
+	}
+	for _, rr := range addrs {
+		if ip, ok := common.RRAddr(rr); ok && s.hiddenFromExternal(ip) {
+			return true
+		}
 	}
-	return true
+	return false
 }
 
-// Name 插件名称
-func (s *SplitNet) Name() string { return "splitnet" }
+// hiddenFromExternal strict 模式下是否对外网客户端隐藏该地址：落在内网网段内或为私有地址
+func (s *SplitNet) hiddenFromExternal(ip netip.Addr) bool {
+	return s.isInternalIP(ip) || isPrivateAddr(ip)
+}
 
-// InitAndUpdateCIDR 初始化并定期更新内网网段
-func (s *SplitNet) InitAndUpdateCIDR() {
-	s.fetchCIDR()
+// rrsetKey 记录集标识，签名按所覆盖的类型归入对应记录集
+type rrsetKey struct {
+	name  string
+	rtype uint16
+}
 
-	// 初始化LRU缓存
-	cacheSize := s.CacheSize
-	if cacheSize <= 0 {
-		cacheSize = 1024 // 默认值
-	}
-	cache, err := lru.New(cacheSize)
-	if err != nil {
-		log.Printf("[splitnet] LRU缓存初始化失败: %v", err)
-	} else {
-		s.IpCache = cache
+// keyOf 记录所属记录集的标识
+func keyOf(rr dns.RR) rrsetKey {
+	hdr := rr.Header()
+	key := rrsetKey{dns.CanonicalName(hdr.Name), hdr.Rrtype}
+	if sig, ok := rr.(*dns.RRSIG); ok {
+		key.rtype = sig.TypeCovered
 	}
+	return key
+}
 
-	go func() {
-		for {
-			time.Sleep(s.ApiInterval)
-			s.fetchCIDR()
+// dropHidden 去除对外网客户端隐藏的地址记录；记录集有记录被去除时签名已失效，一并删除
+func (s *SplitNet) dropHidden(rrs []dns.RR) ([]dns.RR, bool) {
+	stale := make(map[rrsetKey]bool)
+	for _, rr := range rrs {
+		if ip, ok := common.RRAddr(rr); ok && s.hiddenFromExternal(ip) {
+			stale[keyOf(rr)] = true
+		}
+	}
+	if len(stale) == 0 {
+		return rrs, false
+	}
+	kept := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		if ip, ok := common.RRAddr(rr); ok && s.hiddenFromExternal(ip) {
+			continue
+		}
+		if _, ok := rr.(*dns.RRSIG); ok && stale[keyOf(rr)] {
+			continue
 		}
-	}()
+		kept = append(kept, rr)
+	}
+	return kept, true
 }
 
-// fetchCIDR 从API获取内网网段
-func (s *SplitNet) fetchCIDR() {
-	resp, err := http.Get(s.ApiUrl)
-	if err != nil {
-		log.Printf("[splitnet] fetch API error: %v", err)
-		return
+// stripInternal strict 模式下外网客户端的应答去除 CNAME 链末端以外的内网地址记录，以及权威段、附加段中的
+// 内网地址（如 MX/SRV/NS 的胶水记录），链末端的地址记录另行筛选；返回去除后的其余应答记录
+func (s *SplitNet) stripInternal(m *dns.Msg, addrs, others []dns.RR) []dns.RR {
+	if kept, dropped := s.dropHidden(others); dropped {
+		others = kept
+		common.SetAddrRRs(m, others, addrs)
 	}
-	defer resp.Body.Close()
+	m.Ns, _ = s.dropHidden(m.Ns)
+	m.Extra, _ = s.dropHidden(m.Extra)
+	return others
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("[splitnet] read API body error: %v", err)
-		return
+// setNodata strict 模式下去除全部地址后的应答改为 NODATA：权威段只保留 SOA（下游响应没有时合成一条，
+// 按过滤模式的域名或查询名称作为区域），附加段只保留 OPT，便于解析器做否定缓存
+func (s *SplitNet) setNodata(r, m *dns.Msg, ttl uint32) {
+	var soa dns.RR
+	for _, rr := range m.Ns {
+		if _, ok := rr.(*dns.SOA); ok {
+			soa = rr
+			break
+		}
 	}
+	if soa == nil {
+		if ttl == 0 {
+			ttl = nodataTTL
+		}
+		zone := "."
+		if len(r.Question) > 0 {
+			zone = r.Question[0].Name
+			if z := s.zones.Matches(zone); z != "" {
+				zone = z
+			}
+		}
+		soa = &dns.SOA{
+			Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+			Ns:      dnsutil.Join("ns.dns", zone),
+			Mbox:    dnsutil.Join("hostmaster", zone),
+			Serial:  uint32(time.Now().Unix()),
+			Refresh: 7200,
+			Retry:   1800,
+			Expire:  86400,
+			Minttl:  ttl,
+		}
+	}
+	m.Ns = []dns.RR{soa}
+	extra := m.Extra[:0]
+	for _, rr := range m.Extra {
+		if _, ok := rr.(*dns.OPT); ok {
+			extra = append(extra, rr)
+		}
+	}
+	m.Extra = extra
+}
 
-	var cidrList []CIDREntry
-	if err := json.Unmarshal(body, &cidrList); err != nil {
-		log.Printf("[splitnet] unmarshal API json error: %v", err)
-		return
+// isInternalIP 判断应答地址是否为内网记录：落在按内网处理的视图（见 isInternalClient）的网段内；
+// partner 等外网视图的网段只用于划分客户端，不作为内网记录
+func (s *SplitNet) isInternalIP(ip netip.Addr) bool {
+	entry, ok := s.Table.Lookup(ip)
+	if !ok {
+		return false
+	}
+	view := entry.Value.View
+	if view == "" {
+		view = ViewInternal
 	}
+	return s.isInternalClient(view)
+}
 
-	s.ApiLock.Lock()
-	// 构建Ranger
-	ranger := cidranger.NewPCTrieRanger()
-	for _, entry := range cidrList {
-		_, network, err := net.ParseCIDR(entry.CIDR)
-		if err == nil {
-			ranger.Insert(&cidrRangerEntry{network: *network, desc: entry.Desc})
+// Name 插件名称
+func (s *SplitNet) Name() string { return "splitnet" }
+
+// InitAndUpdateCIDR 加载内联网段，并启动本地文件监听与 API 定时拉取
+func (s *SplitNet) InitAndUpdateCIDR() {
+	s.Table = common.NewCIDRTable[cidrInfo](s.CacheSize)
+	s.Table.OnLookup = observeLookup
+	for _, view := range s.Views {
+		view.Start(s.Log)
+	}
+	s.Sources.Name = "内网网段"
+	s.Sources.Log = s.Log
+	s.Sources.Key = cidrKey
+	s.Sources.Format = common.FormatOf(s.FilePath)
+	s.Sources.FromRecord = cidrRecord
+	s.Sources.Build = s.rebuild
+	if len(s.Inline) > 0 {
+		s.Sources.Set(common.SourceInline, s.Inline)
+	}
+	if s.FilePath != "" {
+		s.File = &common.FileWatcher{
+			Log:    s.Log,
+			Path:   s.FilePath,
+			OnData: s.Sources.LoadFile,
 		}
+		s.File.Start()
 	}
-	s.Ranger = ranger
-	s.InternalCIDR = nil
-	for _, entry := range cidrList {
-		_, network, err := net.ParseCIDR(entry.CIDR)
-		if err == nil {
-			s.InternalCIDR = append(s.InternalCIDR, network)
+	if s.ApiUrl != "" {
+		s.Fetcher = &common.Fetcher{
+			FetchConfig: s.Fetch,
+			Log:         s.Log,
+			URL:         s.ApiUrl,
+			OnData:      s.Sources.LoadAPI,
+			OnDelta:     s.Sources.LoadDelta,
+			Observe:     observeFetch,
+			Snapshot:    s.Snapshot,
+			OnSnapshot:  observeSnapshot,
 		}
+		s.Fetcher.Start()
+	}
+}
+
+// Stop 停止本地文件监听与 API 拉取
+func (s *SplitNet) Stop() error {
+	if s.File != nil {
+		s.File.Stop()
 	}
-	// 清空缓存
-	if s.IpCache != nil {
-		s.IpCache.Purge()
+	for _, view := range s.Views {
+		view.Stop()
 	}
-	s.ApiLock.Unlock()
-	log.Printf("[splitnet] 内网网段已热加载，共 %d 个网段", len(s.InternalCIDR))
+	if s.Fetcher != nil {
+		s.Fetcher.Stop()
+	}
+	return nil
 }
 
-// cidrRangerEntry 实现cidranger.RangerEntry接口
-type cidrRangerEntry struct {
-	network net.IPNet
-	desc    string
+// cidrRecord 解析 CSV 网段文件的一行，需要 cidr 列，desc、view 列可选
+func cidrRecord(record map[string]string) (CIDREntry, error) {
+	return CIDREntry{CIDR: record["cidr"], Desc: record["desc"], View: record["view"]}, nil
 }
 
-func (e *cidrRangerEntry) Network() net.IPNet {
-	return e.network
+// cidrKey 网段条目的主键，按掩码归一化
+func cidrKey(e CIDREntry) string {
+	if prefix, err := netip.ParsePrefix(e.CIDR); err == nil {
+		return prefix.Masked().String()
+	}
+	return e.CIDR
 }
 
-func (e *cidrRangerEntry) Desc() string {
-	return e.desc
+// rebuild 用合并后的网段重建查找表
+func (s *SplitNet) rebuild(merged []CIDREntry) int {
+	entries := make([]common.Entry[cidrInfo], 0, len(merged))
+	for _, entry := range merged {
+		prefix, err := netip.ParsePrefix(entry.CIDR)
+		if err == nil {
+			info := cidrInfo{Desc: entry.Desc, View: entry.View}
+			entries = append(entries, common.Entry[cidrInfo]{Prefix: prefix.Masked(), Value: info})
+		}
+	}
+	count := s.Table.Replace(entries)
+	cidrEntries.Set(float64(count))
+	reloadTime.SetToCurrentTime()
+	return count
 }
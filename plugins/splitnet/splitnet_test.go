@@ -0,0 +1,273 @@
+package splitnet
+
+import (
+	"context"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+// rrs 解析一组记录
+func rrs(t *testing.T, lines ...string) []dns.RR {
+	t.Helper()
+	var out []dns.RR
+	for _, line := range lines {
+		rr, err := dns.NewRR(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rr)
+	}
+	return out
+}
+
+// rrStrings 记录的文本形式，便于比较
+func rrStrings(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		out = append(out, rr.String())
+	}
+	return out
+}
+
+// rrTypes 记录的类型，便于比较合成的记录
+func rrTypes(rrs []dns.RR) []string {
+	var out []string
+	for _, rr := range rrs {
+		out = append(out, dns.TypeToString[rr.Header().Rrtype])
+	}
+	return out
+}
+
+// newTestSplitNet 创建测试用插件，100.64.0.0/10 为内网网段，下游返回 answer/ns/extra
+func newTestSplitNet(t *testing.T, mode string, answer, ns, extra []dns.RR) *SplitNet {
+	t.Helper()
+	s := &SplitNet{Mode: mode, Log: common.NewLogger("splitnet"), Table: common.NewCIDRTable[cidrInfo](0)}
+	s.Table.Replace([]common.Entry[cidrInfo]{{Prefix: netip.MustParsePrefix("100.64.0.0/10")}})
+	s.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer, m.Ns, m.Extra = answer, ns, extra
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+	return s
+}
+
+func TestServeDNSModes(t *testing.T) {
+	const external, internal = "203.0.113.1", "100.64.1.1"
+	tests := []struct {
+		name       string
+		mode       string
+		client     string
+		qname      string
+		qtype      uint16
+		answer     []string
+		ns         []string
+		extra      []string
+		wantAnswer []string
+		wantNs     []string // 权威段记录类型
+		wantExtra  []string
+	}{
+		{
+			name: "prefer external gets external", mode: ModePrefer, client: external, qname: "www.example.org.", qtype: dns.TypeA,
+			answer:     []string{"www.example.org. 300 IN A 100.64.0.1", "www.example.org. 300 IN A 198.51.100.1"},
+			wantAnswer: []string{"www.example.org.\t300\tIN\tA\t198.51.100.1"},
+		},
+		{
+			name: "prefer internal gets internal", mode: ModePrefer, client: internal, qname: "www.example.org.", qtype: dns.TypeA,
+			answer:     []string{"www.example.org. 300 IN A 100.64.0.1", "www.example.org. 300 IN A 198.51.100.1"},
+			wantAnswer: []string{"www.example.org.\t300\tIN\tA\t100.64.0.1"},
+		},
+		{
+			name: "prefer external without external falls back", mode: ModePrefer, client: external, qname: "www.example.org.", qtype: dns.TypeA,
+			answer:     []string{"www.example.org. 300 IN A 100.64.0.1", "www.example.org. 300 IN A 100.64.0.2"},
+			wantAnswer: []string{"www.example.org.\t300\tIN\tA\t100.64.0.1", "www.example.org.\t300\tIN\tA\t100.64.0.2"},
+		},
+		{
+			name: "prefer keeps private glue", mode: ModePrefer, client: external, qname: "example.org.", qtype: dns.TypeMX,
+			answer:     []string{"example.org. 300 IN MX 10 mail.example.org."},
+			extra:      []string{"mail.example.org. 300 IN A 10.0.0.25"},
+			wantAnswer: []string{"example.org.\t300\tIN\tMX\t10 mail.example.org."},
+			wantExtra:  []string{"mail.example.org.\t300\tIN\tA\t10.0.0.25"},
+		},
+		{
+			name: "strict single private address returns nodata", mode: ModeStrict, client: external, qname: "www.example.org.", qtype: dns.TypeA,
+			answer: []string{"www.example.org. 300 IN A 10.0.0.1"},
+			ns:     []string{"example.org. 300 IN NS ns1.example.org."},
+			extra:  []string{"ns1.example.org. 300 IN A 198.51.100.53"},
+			wantNs: []string{"SOA"},
+		},
+		{
+			name: "strict nodata keeps downstream soa", mode: ModeStrict, client: external, qname: "www.example.org.", qtype: dns.TypeA,
+			answer: []string{"www.example.org. 300 IN A 100.64.0.1", "www.example.org. 300 IN A 192.168.1.1"},
+			ns:     []string{"example.org. 300 IN SOA ns1.example.org. hostmaster.example.org. 1 7200 1800 86400 60"},
+			wantNs: []string{"SOA"},
+		},
+		{
+			name: "strict nodata keeps cname chain", mode: ModeStrict, client: external, qname: "www.example.org.", qtype: dns.TypeA,
+			answer:     []string{"www.example.org. 300 IN CNAME lb.example.org.", "lb.example.org. 300 IN A 100.64.0.1"},
+			wantAnswer: []string{"www.example.org.\t300\tIN\tCNAME\tlb.example.org."},
+			wantNs:     []string{"SOA"},
+		},
+		{
+			name: "strict drops private glue of mx", mode: ModeStrict, client: external, qname: "example.org.", qtype: dns.TypeMX,
+			answer:     []string{"example.org. 300 IN MX 10 mail.example.org."},
+			extra:      []string{"mail.example.org. 300 IN A 10.0.0.25", "mail.example.org. 300 IN A 198.51.100.25"},
+			wantAnswer: []string{"example.org.\t300\tIN\tMX\t10 mail.example.org."},
+			wantExtra:  []string{"mail.example.org.\t300\tIN\tA\t198.51.100.25"},
+		},
+		{
+			name: "strict drops internal glue of srv and ns", mode: ModeStrict, client: external, qname: "_sip._tcp.example.org.", qtype: dns.TypeSRV,
+			answer:     []string{"_sip._tcp.example.org. 300 IN SRV 0 5 5060 sip.example.org."},
+			ns:         []string{"example.org. 300 IN NS ns1.example.org."},
+			extra:      []string{"sip.example.org. 300 IN A 100.64.0.60", "ns1.example.org. 300 IN AAAA fd00::53"},
+			wantAnswer: []string{"_sip._tcp.example.org.\t300\tIN\tSRV\t0 5 5060 sip.example.org."},
+			wantNs:     []string{"NS"},
+		},
+		{
+			name: "strict drops non-terminal addresses", mode: ModeStrict, client: external, qname: "www.example.org.", qtype: dns.TypeA,
+			answer: []string{
+				"www.example.org. 300 IN CNAME lb.example.org.",
+				"db.example.org. 300 IN A 10.0.0.2",
+				"lb.example.org. 300 IN A 198.51.100.1",
+			},
+			wantAnswer: []string{"www.example.org.\t300\tIN\tCNAME\tlb.example.org.", "lb.example.org.\t300\tIN\tA\t198.51.100.1"},
+		},
+		{
+			name: "strict internal client keeps everything", mode: ModeStrict, client: internal, qname: "example.org.", qtype: dns.TypeMX,
+			answer:     []string{"example.org. 300 IN MX 10 mail.example.org."},
+			extra:      []string{"mail.example.org. 300 IN A 10.0.0.25"},
+			wantAnswer: []string{"example.org.\t300\tIN\tMX\t10 mail.example.org."},
+			wantExtra:  []string{"mail.example.org.\t300\tIN\tA\t10.0.0.25"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSplitNet(t, tt.mode, rrs(t, tt.answer...), rrs(t, tt.ns...), rrs(t, tt.extra...))
+			rec := &recorder{ResponseWriter: &test.ResponseWriter{RemoteIP: tt.client}}
+			r := new(dns.Msg)
+			r.SetQuestion(tt.qname, tt.qtype)
+			if _, err := s.ServeDNS(context.Background(), rec, r); err != nil {
+				t.Fatal(err)
+			}
+			m := rec.msg
+			if m == nil {
+				t.Fatal("no response written")
+			}
+			if m.Rcode != dns.RcodeSuccess {
+				t.Errorf("rcode = %s, want NOERROR", dns.RcodeToString[m.Rcode])
+			}
+			if got := rrStrings(m.Answer); !reflect.DeepEqual(got, tt.wantAnswer) {
+				t.Errorf("answer = %q, want %q", got, tt.wantAnswer)
+			}
+			if got := rrTypes(m.Ns); !reflect.DeepEqual(got, tt.wantNs) {
+				t.Errorf("ns = %q, want %q", got, tt.wantNs)
+			}
+			if got := rrStrings(m.Extra); !reflect.DeepEqual(got, tt.wantExtra) {
+				t.Errorf("extra = %q, want %q", got, tt.wantExtra)
+			}
+		})
+	}
+}
+
+func TestViewClassification(t *testing.T) {
+	s := &SplitNet{
+		Table: common.NewCIDRTable[cidrInfo](0),
+		Views: map[string]*View{
+			"office":  {Name: "office", Internal: true},
+			"partner": {Name: "partner", Upstreams: []string{"10.20.0.53:53"}},
+		},
+	}
+	s.Table.Replace([]common.Entry[cidrInfo]{
+		{Prefix: netip.MustParsePrefix("100.64.0.0/10")},
+		{Prefix: netip.MustParsePrefix("10.8.0.0/16"), Value: cidrInfo{View: "vpn"}},
+		{Prefix: netip.MustParsePrefix("10.30.0.0/16"), Value: cidrInfo{View: "office"}},
+		{Prefix: netip.MustParsePrefix("172.20.0.0/16"), Value: cidrInfo{View: "partner"}},
+	})
+	tests := []struct {
+		ip             string
+		wantView       string
+		wantClient     bool // 客户端按内网客户端过滤
+		wantInternalIP bool // 应答地址视为内网记录
+	}{
+		{ip: "100.64.0.1", wantView: ViewInternal, wantClient: true, wantInternalIP: true},
+		{ip: "10.8.1.1", wantView: "vpn", wantClient: true, wantInternalIP: true},
+		{ip: "10.30.1.1", wantView: "office", wantClient: true, wantInternalIP: true},
+		{ip: "172.20.1.1", wantView: "partner"},
+		{ip: "198.51.100.1", wantView: ViewPublic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			ip := netip.MustParseAddr(tt.ip)
+			view := s.viewOf(ip)
+			if view != tt.wantView {
+				t.Errorf("viewOf = %s, want %s", view, tt.wantView)
+			}
+			if got := s.isInternalClient(view); got != tt.wantClient {
+				t.Errorf("isInternalClient = %v, want %v", got, tt.wantClient)
+			}
+			if got := s.isInternalIP(ip); got != tt.wantInternalIP {
+				t.Errorf("isInternalIP = %v, want %v", got, tt.wantInternalIP)
+			}
+		})
+	}
+}
+
+func TestSetNodataSOA(t *testing.T) {
+	tests := []struct {
+		name     string
+		zones    map[string]string
+		ttl      uint32
+		wantZone string
+		wantTTL  uint32
+	}{
+		{name: "query name as zone", wantZone: "www.example.org.", wantTTL: nodataTTL},
+		{name: "mode zone", zones: map[string]string{"example.org.": ModeStrict}, ttl: 30, wantZone: "example.org.", wantTTL: 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SplitNet{ModeZones: tt.zones}
+			for zone := range tt.zones {
+				s.zones = append(s.zones, zone)
+			}
+			r := new(dns.Msg)
+			r.SetQuestion("www.example.org.", dns.TypeA)
+			r.SetEdns0(1232, false)
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Extra = append(rrs(t, "ns1.example.org. 300 IN A 198.51.100.53"), r.IsEdns0())
+			s.setNodata(r, m, tt.ttl)
+			if len(m.Ns) != 1 {
+				t.Fatalf("ns = %v, want one SOA", m.Ns)
+			}
+			soa, ok := m.Ns[0].(*dns.SOA)
+			if !ok {
+				t.Fatalf("ns = %v, want SOA", m.Ns[0])
+			}
+			if soa.Hdr.Name != tt.wantZone || soa.Hdr.Ttl != tt.wantTTL || soa.Minttl != tt.wantTTL {
+				t.Errorf("soa = %v, want zone %s ttl %d", soa, tt.wantZone, tt.wantTTL)
+			}
+			if got := rrTypes(m.Extra); !reflect.DeepEqual(got, []string{"OPT"}) {
+				t.Errorf("extra = %q, want only OPT", got)
+			}
+		})
+	}
+}
+
+// recorder 记录写出的响应
+type recorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (r *recorder) WriteMsg(m *dns.Msg) error {
+	r.msg = m
+	return nil
+}
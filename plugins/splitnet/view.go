@@ -0,0 +1,223 @@
+package splitnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"coredns-plugins/plugins/common"
+
+	"github.com/miekg/dns"
+)
+
+// 内置视图名称：网段条目未指定 view 时属于 internal，未命中任何网段的客户端属于 public
+const (
+	ViewInternal = "internal"
+	ViewPublic   = "public"
+)
+
+// viewTTL 视图静态应答与 hosts 文件记录的 TTL，与 hosts 插件默认值一致
+const viewTTL = 3600
+
+// viewTimeout 视图上游单次查询超时
+const viewTimeout = 2 * time.Second
+
+// 视图应答来源，用于指标与决策日志
+const (
+	backendAnswer  = "answer"
+	backendHosts   = "hosts"
+	backendForward = "forward"
+)
+
+// View 视图：一组客户端网段（CIDR 条目的 view 字段）对应的解析方式。
+// 依次尝试 静态应答 → hosts 文件 → 上游，均未命中时交给下游插件并按内外网过滤；
+// 除 internal 视图与标记为 internal 的视图外，视图客户端按外网客户端过滤
+type View struct {
+	Name      string                    // 视图名称
+	Internal  bool                      // 视图客户端按内网客户端过滤
+	Answers   map[string][]netip.Addr   // answer 指令配置的静态应答，键为 FQDN
+	HostsPath string                    // hosts 格式文件路径
+	File      *common.FileWatcher       // hosts 文件监听
+	Upstreams []string                  // 上游地址（host:port），按顺序尝试
+	hosts     atomic.Pointer[hostsData] // hosts 文件内容，热加载时原子替换
+}
+
+// hostsData 解析后的 hosts 文件：FQDN -> 地址
+type hostsData map[string][]netip.Addr
+
+// parseDirective 解析视图指令的子命令：
+//
+//	view NAME answer DOMAIN IP...
+//	view NAME hosts FILE
+//	view NAME forward ADDR...
+//	view NAME internal
+func (v *View) parseDirective(kind string, args []string) error {
+	switch kind {
+	case "internal":
+		if len(args) != 0 {
+			return errors.New("internal takes no arguments")
+		}
+		v.Internal = true
+	case "answer":
+		if len(args) < 2 {
+			return errors.New("answer requires a domain and at least one IP")
+		}
+		name := args[0]
+		if _, ok := dns.IsDomainName(name); !ok {
+			return fmt.Errorf("invalid domain: %s", name)
+		}
+		name = dns.CanonicalName(name)
+		for _, arg := range args[1:] {
+			ip, err := netip.ParseAddr(arg)
+			if err != nil {
+				return fmt.Errorf("invalid IP: %s", arg)
+			}
+			if v.Answers == nil {
+				v.Answers = make(map[string][]netip.Addr)
+			}
+			v.Answers[name] = append(v.Answers[name], ip.Unmap())
+		}
+	case "hosts":
+		if len(args) != 1 {
+			return errors.New("hosts requires a file path")
+		}
+		v.HostsPath = args[0]
+	case "forward":
+		if len(args) == 0 {
+			return errors.New("forward requires at least one upstream")
+		}
+		for _, arg := range args {
+			addr := arg
+			if _, _, err := net.SplitHostPort(arg); err != nil {
+				addr = net.JoinHostPort(arg, "53")
+			}
+			if _, err := netip.ParseAddrPort(addr); err != nil {
+				return fmt.Errorf("invalid upstream: %s", arg)
+			}
+			v.Upstreams = append(v.Upstreams, addr)
+		}
+	default:
+		return fmt.Errorf("unknown view property '%s'", kind)
+	}
+	return nil
+}
+
+// Start 启动 hosts 文件监听
+func (v *View) Start(log *common.Logger) {
+	if v.HostsPath == "" {
+		return
+	}
+	v.File = &common.FileWatcher{
+		Log:  log,
+		Path: v.HostsPath,
+		OnData: func(body []byte) error {
+			hosts, err := parseHosts(body)
+			if err != nil {
+				return err
+			}
+			v.hosts.Store(&hosts)
+			log.Infof("view %s hosts file reloaded (%d names)", v.Name, len(hosts))
+			return nil
+		},
+	}
+	v.File.Start()
+}
+
+// Stop 停止 hosts 文件监听
+func (v *View) Stop() error {
+	if v.File != nil {
+		v.File.Stop()
+	}
+	return nil
+}
+
+// resolve 按视图配置解析查询，返回 nil 表示交给下游插件
+func (v *View) resolve(ctx context.Context, r *dns.Msg) (*dns.Msg, string, error) {
+	q := r.Question[0]
+	name := dns.CanonicalName(q.Name)
+	if addrs, ok := v.Answers[name]; ok {
+		return addrReply(r, addrs), backendAnswer, nil
+	}
+	if hosts := v.hosts.Load(); hosts != nil {
+		if addrs, ok := (*hosts)[name]; ok {
+			return addrReply(r, addrs), backendHosts, nil
+		}
+	}
+	if len(v.Upstreams) > 0 {
+		m, err := v.forward(ctx, r)
+		return m, backendForward, err
+	}
+	return nil, "", nil
+}
+
+// forward 依次向上游转发查询，UDP 响应被截断时改用 TCP 重试
+func (v *View) forward(ctx context.Context, r *dns.Msg) (*dns.Msg, error) {
+	var lastErr error
+	for _, upstream := range v.Upstreams {
+		m, err := exchange(ctx, "udp", upstream, r)
+		if err == nil && m.Truncated {
+			m, err = exchange(ctx, "tcp", upstream, r)
+		}
+		if err == nil {
+			return m, nil
+		}
+		lastErr = err
+	}
+	return nil, fmt.Errorf("view %s forward failed: %w", v.Name, lastErr)
+}
+
+func exchange(ctx context.Context, network, upstream string, r *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{Net: network, Timeout: viewTimeout}
+	m, _, err := client.ExchangeContext(ctx, r, upstream)
+	return m, err
+}
+
+// addrReply 用静态地址生成应答，只返回与查询类型匹配的地址；名称存在但没有该类型地址时为 NODATA
+func addrReply(r *dns.Msg, addrs []netip.Addr) *dns.Msg {
+	q := r.Question[0]
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: viewTTL}
+	for _, addr := range addrs {
+		switch {
+		case q.Qtype == dns.TypeA && addr.Is4():
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: addr.AsSlice()})
+		case q.Qtype == dns.TypeAAAA && addr.Is6():
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()})
+		}
+	}
+	return m
+}
+
+// parseHosts 解析 hosts 格式文件：每行 IP NAME...，# 之后为注释
+func parseHosts(body []byte) (hostsData, error) {
+	hosts := make(hostsData)
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: missing hostname", line)
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid IP %s", line, fields[0])
+		}
+		for _, name := range fields[1:] {
+			name = dns.CanonicalName(name)
+			hosts[name] = append(hosts[name], ip.Unmap())
+		}
+	}
+	return hosts, scanner.Err()
+}